@@ -0,0 +1,131 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"lab-ibnu-sina-queue/internal/audit"
+	"lab-ibnu-sina-queue/internal/auth"
+	"lab-ibnu-sina-queue/internal/handlers"
+	"lab-ibnu-sina-queue/internal/queue"
+)
+
+// =====================
+// COUNTER ACTIONS
+// =====================
+//
+// Shared by the REST handlers and the WebSocket commands, so both change
+// the queue, audit and broadcast the same way.
+
+// actionStatus maps the error of an action to an HTTP status code
+func actionStatus(err error) int {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return http.StatusNotFound
+	case errors.Is(err, queue.ErrInvalidTransition):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// callTicket calls a ticket to a counter; action is "call" or "call_manual"
+func callTicket(actor auth.Identity, action string, ticketID, counter int) (queue.Ticket, error) {
+	previous, _ := queue.GetTicket(ticketID)
+
+	ticket, err := queue.CallTicket(ticketID, counter)
+	if err != nil {
+		return queue.Ticket{}, err
+	}
+
+	audit.Record(actor, action, ticket.ID, fmt.Sprintf("%s to counter %d", ticket.FormattedCode, counter))
+
+	fmt.Printf("[CALL] Calling ticket %s to Counter %d\n", ticket.FormattedCode, counter)
+
+	// Broadcast to display
+	call := callAnnouncement(ticket)
+	hub.Publish(handlers.EventCallTicket, call, ticketTopics(ticket)...)
+	announceCall("call", call)
+	publishBoard(counter)
+	// A ticket called again to another counter leaves the old one
+	if previous.Counter != counter {
+		publishBoard(previous.Counter)
+	}
+	return ticket, nil
+}
+
+// callNext calls the oldest waiting ticket, of a category if categoryID is set
+func callNext(actor auth.Identity, counter, categoryID int) (queue.Ticket, error) {
+	ticket, err := queue.CallNextTicket(counter, categoryID)
+	if err != nil {
+		return queue.Ticket{}, err
+	}
+
+	audit.Record(actor, "call", ticket.ID, fmt.Sprintf("%s to counter %d", ticket.FormattedCode, counter))
+
+	fmt.Printf("[CALL] Calling next ticket %s to Counter %d\n", ticket.FormattedCode, counter)
+
+	call := callAnnouncement(ticket)
+	hub.Publish(handlers.EventCallTicket, call, ticketTopics(ticket)...)
+	announceCall("call", call)
+	publishBoard(counter)
+	return ticket, nil
+}
+
+// recallTicket announces the ticket called to a counter again
+func recallTicket(actor auth.Identity, counter int) (queue.Ticket, error) {
+	ticket, err := queue.RecallTicket(counter)
+	if err != nil {
+		return queue.Ticket{}, err
+	}
+
+	audit.Record(actor, "recall", ticket.ID, fmt.Sprintf("%s to counter %d", ticket.FormattedCode, counter))
+
+	fmt.Printf("[RECALL] Recalling ticket %s to Counter %d\n", ticket.FormattedCode, counter)
+
+	// Broadcast recall
+	call := callAnnouncement(ticket)
+	hub.Publish(handlers.EventRecallTicket, call, ticketTopics(ticket)...)
+	announceCall("recall", call)
+	publishBoard(counter)
+	return ticket, nil
+}
+
+// serveTicket marks a called ticket as being served
+func serveTicket(actor auth.Identity, ticketID int) (queue.Ticket, error) {
+	ticket, err := queue.ServeTicket(ticketID)
+	if err != nil {
+		return queue.Ticket{}, err
+	}
+
+	audit.Record(actor, "serve", ticket.ID, ticket.FormattedCode)
+
+	fmt.Printf("[SERVE] Serving ticket %s at Counter %d\n", ticket.FormattedCode, ticket.Counter)
+
+	hub.Publish(handlers.EventServeTicket, ticket, ticketTopics(ticket)...)
+	publishBoard(ticket.Counter)
+	return ticket, nil
+}
+
+// skipTicket marks a ticket as skipped
+func skipTicket(actor auth.Identity, ticketID int) error {
+	if err := queue.SkipTicket(ticketID); err != nil {
+		return err
+	}
+
+	audit.Record(actor, "skip", ticketID, "")
+	publishTicketBoard(ticketID)
+	return nil
+}
+
+// finishTicket marks a ticket as finished
+func finishTicket(actor auth.Identity, ticketID int) error {
+	if err := queue.FinishTicket(ticketID); err != nil {
+		return err
+	}
+
+	audit.Record(actor, "finish", ticketID, "")
+	publishTicketBoard(ticketID)
+	return nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"lab-ibnu-sina-queue/internal/audit"
+	"lab-ibnu-sina-queue/internal/handlers"
+	"lab-ibnu-sina-queue/internal/queue"
+
+	"github.com/gorilla/mux"
+)
+
+// =====================
+// ANNOUNCEMENTS
+// =====================
+
+type AnnouncementRequest struct {
+	Text      string     `json:"text"`
+	Priority  string     `json:"priority"`
+	StartsAt  *time.Time `json:"starts_at"`
+	EndsAt    *time.Time `json:"ends_at"`
+	DeviceIDs []int      `json:"device_ids"`
+}
+
+// announcement fills in the defaults: normal priority, starting now
+func (req AnnouncementRequest) announcement() queue.Announcement {
+	a := queue.Announcement{
+		Text:      req.Text,
+		Priority:  req.Priority,
+		StartsAt:  time.Now(),
+		EndsAt:    req.EndsAt,
+		DeviceIDs: req.DeviceIDs,
+	}
+	if a.Priority == "" {
+		a.Priority = "normal"
+	}
+	if req.StartsAt != nil {
+		a.StartsAt = *req.StartsAt
+	}
+	return a
+}
+
+// publishAnnouncement shows an announcement on the displays it targets
+func publishAnnouncement(a queue.Announcement) {
+	topics := make([]string, 0, len(a.DeviceIDs))
+	for _, id := range a.DeviceIDs {
+		topics = append(topics, handlers.DeviceTopic(id))
+	}
+	hub.Publish(handlers.EventAnnouncement, a, topics...)
+}
+
+// withdrawAnnouncement removes an announcement from every display
+func withdrawAnnouncement(id int) {
+	hub.Publish(handlers.EventAnnouncementRemoved, map[string]int{"id": id})
+}
+
+func announcementError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, queue.ErrInvalidAnnouncement):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Announcement not found", http.StatusNotFound)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func ListAnnouncementsHandler(w http.ResponseWriter, r *http.Request) {
+	announcements, err := queue.ListAnnouncements()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(announcements)
+}
+
+func CreateAnnouncementHandler(w http.ResponseWriter, r *http.Request) {
+	var req AnnouncementRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	a := req.announcement()
+	a.CreatedBy = currentIdentity(r).Username
+	a, err := queue.CreateAnnouncement(a)
+	if err != nil {
+		announcementError(w, err)
+		return
+	}
+
+	audit.Record(currentIdentity(r), "announcement_create", 0, fmt.Sprintf("[%s] %s", a.Priority, a.Text))
+
+	if a.ActiveAt(time.Now()) {
+		publishAnnouncement(a)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a)
+}
+
+func UpdateAnnouncementHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid announcement ID", http.StatusBadRequest)
+		return
+	}
+
+	var req AnnouncementRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := queue.GetAnnouncement(id); err != nil {
+		announcementError(w, err)
+		return
+	}
+
+	a := req.announcement()
+	a.ID = id
+	a, err = queue.UpdateAnnouncement(a)
+	if err != nil {
+		announcementError(w, err)
+		return
+	}
+
+	audit.Record(currentIdentity(r), "announcement_update", 0, fmt.Sprintf("[%s] %s", a.Priority, a.Text))
+
+	// The targets may have changed, so take it off every display first
+	withdrawAnnouncement(a.ID)
+	if a.ActiveAt(time.Now()) {
+		publishAnnouncement(a)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a)
+}
+
+func DeleteAnnouncementHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid announcement ID", http.StatusBadRequest)
+		return
+	}
+
+	a, err := queue.GetAnnouncement(id)
+	if err != nil {
+		announcementError(w, err)
+		return
+	}
+	if err := queue.DeleteAnnouncement(id); err != nil {
+		announcementError(w, err)
+		return
+	}
+
+	audit.Record(currentIdentity(r), "announcement_delete", 0, a.Text)
+	withdrawAnnouncement(id)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
+}
+
+// scheduleAnnouncements publishes scheduled announcements when they start
+// and removes them from the displays, and the database, once they end
+func scheduleAnnouncements() {
+	ticker := time.NewTicker(15 * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now()
+
+		started, err := queue.StartDueAnnouncements(now)
+		if err != nil {
+			log.Printf("Error starting announcements: %v", err)
+		}
+		for _, a := range started {
+			fmt.Printf("[ANNOUNCE] Showing announcement %d\n", a.ID)
+			publishAnnouncement(a)
+		}
+
+		expired, err := queue.DeleteExpiredAnnouncements(now)
+		if err != nil {
+			log.Printf("Error expiring announcements: %v", err)
+		}
+		for _, a := range expired {
+			fmt.Printf("[ANNOUNCE] Announcement %d expired\n", a.ID)
+			withdrawAnnouncement(a.ID)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"lab-ibnu-sina-queue/internal/audit"
+	"lab-ibnu-sina-queue/internal/auth"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	sessionCookie = "session"
+	deviceCookie  = "device_key"
+)
+
+// =====================
+// MIDDLEWARE
+// =====================
+
+// bearerToken returns the token of an "Authorization: Bearer" header
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimPrefix(header, "Bearer ")
+	}
+	return ""
+}
+
+// sessionToken reads the login token from the header or the session cookie
+func sessionToken(r *http.Request) string {
+	if token := bearerToken(r); token != "" {
+		return token
+	}
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+// clientIP returns the caller's address. Proxy headers are only trusted
+// when TRUST_PROXY_HEADERS is set, since clients can forge them.
+func clientIP(r *http.Request) string {
+	if os.Getenv("TRUST_PROXY_HEADERS") == "true" {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			return strings.TrimSpace(strings.Split(fwd, ",")[0])
+		}
+		if real := r.Header.Get("X-Real-IP"); real != "" {
+			return real
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// identityForToken resolves a session token or device API key
+func identityForToken(token string, r *http.Request) (auth.Identity, bool) {
+	if auth.IsDeviceKey(token) {
+		device, err := auth.GetDeviceByKey(token)
+		if err != nil {
+			return auth.Identity{}, false
+		}
+		auth.TouchDevice(device.ID, clientIP(r))
+		return device.Identity(), true
+	}
+
+	user, err := auth.GetSessionUser(token)
+	if err != nil {
+		return auth.Identity{}, false
+	}
+	return user.Identity(), true
+}
+
+// authenticate resolves the identity behind a request. An explicit bearer
+// token wins over a staff session cookie, which wins over a device cookie.
+func authenticate(r *http.Request) (auth.Identity, bool) {
+	if token := bearerToken(r); token != "" {
+		return identityForToken(token, r)
+	}
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		if id, ok := identityForToken(c.Value, r); ok {
+			return id, true
+		}
+	}
+	if c, err := r.Cookie(deviceCookie); err == nil {
+		return identityForToken(c.Value, r)
+	}
+	return auth.Identity{}, false
+}
+
+// requirePermission only lets requests through whose identity holds perm
+func requirePermission(perm auth.Permission, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := authenticate(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !id.Can(perm) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r.WithContext(auth.WithIdentity(r.Context(), id)))
+	}
+}
+
+// currentIdentity returns the identity attached by requirePermission
+func currentIdentity(r *http.Request) auth.Identity {
+	id, _ := auth.FromContext(r.Context())
+	return id
+}
+
+// =====================
+// AUTH HANDLERS
+// =====================
+
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type UserRequest struct {
+	Username    string    `json:"username"`
+	DisplayName string    `json:"display_name"`
+	Password    string    `json:"password"`
+	Role        auth.Role `json:"role"`
+	Active      *bool     `json:"active"`
+}
+
+func LoginHandler(w http.ResponseWriter, r *http.Request) {
+	var req LoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, err := auth.Authenticate(req.Username, req.Password)
+	if errors.Is(err, auth.ErrInvalidCredentials) {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Printf("Error during login: %v", err)
+		http.Error(w, "Database Error", http.StatusInternalServerError)
+		return
+	}
+
+	token, expires, err := auth.CreateSession(user.ID)
+	if err != nil {
+		log.Printf("Error creating session: %v", err)
+		http.Error(w, "Database Error", http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	audit.Record(user.Identity(), "login", 0, "")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":      token,
+		"expires_at": expires,
+		"user":       user,
+	})
+}
+
+func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if token := sessionToken(r); token != "" {
+		auth.DeleteSession(token)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    "",
+		Path:     "/",
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "logged_out"})
+}
+
+func MeHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := authenticate(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(id)
+}
+
+// =====================
+// USER MANAGEMENT
+// =====================
+
+func ListUsersHandler(w http.ResponseWriter, r *http.Request) {
+	users, err := auth.ListUsers()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
+
+func CreateUserHandler(w http.ResponseWriter, r *http.Request) {
+	var req UserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Username == "" || len(req.Password) < 8 {
+		http.Error(w, "Username and a password of at least 8 characters are required", http.StatusBadRequest)
+		return
+	}
+
+	user, err := auth.CreateUser(req.Username, req.DisplayName, req.Password, req.Role)
+	if errors.Is(err, auth.ErrInvalidRole) || errors.Is(err, auth.ErrUsernameTaken) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	audit.Record(currentIdentity(r), "user_create", 0, user.Username+" ("+string(user.Role)+")")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+func UpdateUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req UserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Password != "" && len(req.Password) < 8 {
+		http.Error(w, "Password must be at least 8 characters", http.StatusBadRequest)
+		return
+	}
+
+	existing, err := auth.GetUser(id)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	displayName := existing.DisplayName
+	if req.DisplayName != "" {
+		displayName = req.DisplayName
+	}
+	role := existing.Role
+	if req.Role != "" {
+		role = req.Role
+	}
+	active := existing.Active
+	if req.Active != nil {
+		active = *req.Active
+	}
+
+	// Keep admins from locking themselves out
+	if id == currentIdentity(r).UserID && (!active || role != existing.Role) {
+		http.Error(w, "Cannot deactivate or change the role of your own account", http.StatusBadRequest)
+		return
+	}
+
+	user, err := auth.UpdateUser(id, displayName, role, active, req.Password)
+	if errors.Is(err, auth.ErrInvalidRole) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	audit.Record(currentIdentity(r), "user_update", 0, user.Username)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+func ListAuditHandler(w http.ResponseWriter, r *http.Request) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+
+	entries, err := audit.List(limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// cleanupSessions removes expired login sessions every hour
+func cleanupSessions() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := auth.DeleteExpiredSessions(); err != nil {
+			log.Printf("Error cleaning up sessions: %v", err)
+		}
+	}
+}
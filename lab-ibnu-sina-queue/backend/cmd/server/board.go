@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+
+	"lab-ibnu-sina-queue/internal/handlers"
+	"lab-ibnu-sina-queue/internal/queue"
+)
+
+// =====================
+// NOW SERVING BOARD
+// =====================
+
+// boardCounters are the counters always shown on the board, idle or not
+var boardCounters = []int{1, 2, 3}
+
+// initBoard reads the open counters from BOARD_COUNTERS, e.g. "1,2,3,4"
+func initBoard() {
+	v := os.Getenv("BOARD_COUNTERS")
+	if v == "" {
+		return
+	}
+	counters, err := parseIDList(v)
+	if err != nil {
+		log.Printf("Invalid BOARD_COUNTERS %q: %v", v, err)
+		return
+	}
+	boardCounters = counters
+}
+
+// boardFor returns the board limited to the counters of a filter
+func boardFor(filter queue.TicketFilter) ([]queue.BoardEntry, error) {
+	all, err := queue.GetBoard(boardCounters)
+	if err != nil {
+		return nil, err
+	}
+	board := []queue.BoardEntry{}
+	for _, entry := range all {
+		if filter.AllowsCounter(entry.Counter) {
+			board = append(board, entry)
+		}
+	}
+	return board, nil
+}
+
+// publishBoard sends the new state of a counter to board clients. It is
+// tagged with the counter only, so zones limited to categories still see
+// every change of their counters.
+func publishBoard(counter int) {
+	if counter <= 0 {
+		return
+	}
+	entry, err := queue.GetBoardEntry(counter)
+	if err != nil {
+		log.Printf("Error reading counter %d for the board: %v", counter, err)
+		return
+	}
+	hub.Publish(handlers.EventBoardUpdate, entry, handlers.CounterTopic(counter))
+}
+
+// publishBoards sends the state of every open counter, after changes that
+// are not tied to one counter
+func publishBoards() {
+	for _, counter := range boardCounters {
+		publishBoard(counter)
+	}
+}
+
+// publishTicketBoard updates the board for the counter a ticket is at
+func publishTicketBoard(ticketID int) {
+	if t, err := queue.GetTicket(ticketID); err == nil {
+		publishBoard(t.Counter)
+	}
+}
+
+// GetBoardHandler returns the ticket called or served at every open
+// counter of the caller's zone. The counter parameter (e.g. ?counter=1,2)
+// narrows the board within the zone.
+func GetBoardHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := ticketFilterFor(currentIdentity(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var counters []int
+	if query := r.URL.Query(); query.Has("counter") {
+		if counters, err = parseIDList(query.Get("counter")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	board := []queue.BoardEntry{}
+	if filter, ok := filter.Narrow(nil, counters); ok {
+		board, err = boardFor(filter)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(board)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"lab-ibnu-sina-queue/internal/auth"
+	"lab-ibnu-sina-queue/internal/handlers"
+	"lab-ibnu-sina-queue/internal/idempotency"
+)
+
+// =====================
+// WEBSOCKET COMMANDS
+// =====================
+
+// CommandPayload holds the arguments of the counter commands
+type CommandPayload struct {
+	TicketID   int `json:"ticket_id"`
+	Counter    int `json:"counter"`
+	CategoryID int `json:"category_id"`
+}
+
+// commandPermissions lists the commands and the permission each requires,
+// the same as their REST endpoints
+var commandPermissions = map[string]auth.Permission{
+	"call-next": auth.PermQueueOperate,
+	"call":      auth.PermQueueOperate,
+	"recall":    auth.PermQueueOperate,
+	"serve":     auth.PermQueueOperate,
+	"skip":      auth.PermQueueOperate,
+	"finish":    auth.PermQueueOperate,
+}
+
+// runCommand executes a command sent over the socket. A retried command
+// with the same ID gets the original result, like an Idempotency-Key.
+func runCommand(identity auth.Identity, cmd handlers.Command) (interface{}, error) {
+	perm, ok := commandPermissions[cmd.Name]
+	if !ok {
+		return nil, &handlers.CommandError{Code: http.StatusBadRequest, Message: "Unknown command " + cmd.Name}
+	}
+	if !identity.Can(perm) {
+		return nil, &handlers.CommandError{Code: http.StatusForbidden, Message: "Forbidden"}
+	}
+
+	var payload CommandPayload
+	if len(cmd.Payload) > 0 {
+		if err := json.Unmarshal(cmd.Payload, &payload); err != nil {
+			return nil, &handlers.CommandError{Code: http.StatusBadRequest, Message: err.Error()}
+		}
+	}
+
+	if cmd.ID == "" {
+		return executeCommand(identity, cmd.Name, payload)
+	}
+
+	sum := sha256.Sum256(append([]byte("COMMAND "+cmd.Name+"\n"), cmd.Payload...))
+	storeKey := idempotencyScope(identity) + "/ws/" + cmd.ID
+	stored, owner, err := idempotencyStore.Begin(context.Background(), storeKey, hex.EncodeToString(sum[:]))
+	if errors.Is(err, idempotency.ErrKeyReused) {
+		return nil, &handlers.CommandError{Code: http.StatusUnprocessableEntity, Message: err.Error()}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !owner {
+		return storedResult(stored)
+	}
+
+	result, err := executeCommand(identity, cmd.Name, payload)
+	status := http.StatusOK
+	var cmdErr *handlers.CommandError
+	if errors.As(err, &cmdErr) {
+		status = cmdErr.Code
+	} else if err != nil {
+		status = http.StatusInternalServerError
+	}
+
+	if !idempotency.Cacheable(status) {
+		idempotencyStore.Abort(storeKey)
+		return result, err
+	}
+
+	body, _ := json.Marshal(result)
+	if err != nil {
+		body = []byte(err.Error())
+	}
+	idempotencyStore.Complete(storeKey, &idempotency.Response{Status: status, Body: body})
+	return result, err
+}
+
+// storedResult turns the stored outcome of a command back into its result
+func storedResult(stored *idempotency.Response) (interface{}, error) {
+	if stored.Status != http.StatusOK {
+		return nil, &handlers.CommandError{Code: stored.Status, Message: string(stored.Body)}
+	}
+	return json.RawMessage(stored.Body), nil
+}
+
+// executeCommand runs the queue action of a command
+func executeCommand(identity auth.Identity, name string, p CommandPayload) (interface{}, error) {
+	var result interface{}
+	var err error
+
+	switch name {
+	case "call-next":
+		result, err = callNext(identity, p.Counter, p.CategoryID)
+	case "call":
+		result, err = callTicket(identity, "call", p.TicketID, p.Counter)
+	case "recall":
+		result, err = recallTicket(identity, p.Counter)
+	case "serve":
+		result, err = serveTicket(identity, p.TicketID)
+	case "skip":
+		err = skipTicket(identity, p.TicketID)
+		result = map[string]string{"status": "skipped"}
+	case "finish":
+		err = finishTicket(identity, p.TicketID)
+		result = map[string]string{"status": "finished"}
+	}
+
+	if err != nil {
+		return nil, &handlers.CommandError{Code: actionStatus(err), Message: err.Error()}
+	}
+	return result, nil
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"lab-ibnu-sina-queue/internal/audit"
+	"lab-ibnu-sina-queue/internal/auth"
+	"lab-ibnu-sina-queue/internal/handlers"
+	"lab-ibnu-sina-queue/internal/queue"
+
+	"github.com/gorilla/mux"
+)
+
+// deviceKeyLifetime is how long the browser keeps the device cookie; the
+// key itself stays valid until revoked
+const deviceKeyLifetime = 10 * 365 * 24 * time.Hour
+
+type CreateDeviceRequest struct {
+	Name string    `json:"name"`
+	Role auth.Role `json:"role"`
+}
+
+type PairDeviceRequest struct {
+	Code string `json:"code"`
+}
+
+// PairingResponse is returned whenever a pairing code is issued
+type PairingResponse struct {
+	Device      auth.Device `json:"device"`
+	PairingCode string      `json:"pairing_code"`
+}
+
+// DeviceStatus is a device with its live connection state. Connections are
+// those of this server instance.
+type DeviceStatus struct {
+	auth.Device
+	Online          bool       `json:"online"`
+	Connections     int        `json:"connections"`
+	LastHeartbeatAt *time.Time `json:"last_heartbeat_at,omitempty"`
+}
+
+// DeviceCommandRequest is a remote command for a display or kiosk
+type DeviceCommandRequest struct {
+	Command string `json:"command"`
+	Layout  string `json:"layout,omitempty"`
+	Muted   *bool  `json:"muted,omitempty"`
+	ZoneID  *int   `json:"zone_id,omitempty"`
+}
+
+// DeviceCommand is the data of a DEVICE_COMMAND event
+type DeviceCommand struct {
+	Command string `json:"command"`
+	Name    string `json:"name"`
+	Layout  string `json:"layout,omitempty"`
+	Muted   bool   `json:"muted"`
+}
+
+// DeviceSettings is sent to a device in reply to REGISTER
+type DeviceSettings struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	Layout string `json:"layout"`
+	Muted  bool   `json:"muted"`
+}
+
+func ListDevicesHandler(w http.ResponseWriter, r *http.Request) {
+	devices, err := auth.ListDevices()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	live := make(map[int][]handlers.ClientInfo)
+	for _, c := range hub.Clients() {
+		if c.DeviceID != 0 {
+			live[c.DeviceID] = append(live[c.DeviceID], c)
+		}
+	}
+
+	statuses := make([]DeviceStatus, 0, len(devices))
+	for _, d := range devices {
+		status := DeviceStatus{Device: d, LastHeartbeatAt: d.LastSeenAt}
+		for _, c := range live[d.ID] {
+			status.Online = true
+			status.Connections++
+			heartbeat := c.ConnectedAt
+			if c.LastPongAt != nil {
+				heartbeat = *c.LastPongAt
+			}
+			if status.LastHeartbeatAt == nil || heartbeat.After(*status.LastHeartbeatAt) {
+				status.LastHeartbeatAt = &heartbeat
+			}
+		}
+		statuses = append(statuses, status)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(statuses)
+}
+
+func CreateDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	var req CreateDeviceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Name == "" {
+		http.Error(w, "Device name is required", http.StatusBadRequest)
+		return
+	}
+
+	device, code, err := auth.CreateDevice(req.Name, req.Role)
+	if errors.Is(err, auth.ErrInvalidDeviceRole) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	audit.Record(currentIdentity(r), "device_create", 0, device.Name+" ("+string(device.Role)+")")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PairingResponse{Device: device, PairingCode: code})
+}
+
+func ReissuePairingCodeHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid device ID", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := auth.GetDevice(id); err != nil {
+		http.Error(w, "Device not found", http.StatusNotFound)
+		return
+	}
+
+	device, code, err := auth.ReissuePairingCode(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	audit.Record(currentIdentity(r), "device_repair", 0, device.Name)
+
+	// The old key stops working, so drop its live connections
+	hub.RevokeDevice(id)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PairingResponse{Device: device, PairingCode: code})
+}
+
+func RevokeDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid device ID", http.StatusBadRequest)
+		return
+	}
+
+	device, err := auth.GetDevice(id)
+	if err != nil {
+		http.Error(w, "Device not found", http.StatusNotFound)
+		return
+	}
+
+	if err := auth.RevokeDevice(id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	audit.Record(currentIdentity(r), "device_revoke", 0, device.Name)
+
+	hub.RevokeDevice(id)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "revoked"})
+}
+
+// DeviceCommandHandler pushes a remote command to a device through the hub:
+// reload the page, identify (show its name on screen), switch layout, mute
+// audio or assign a display zone. Layout, mute and zone are stored so they
+// survive a reload.
+func DeviceCommandHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid device ID", http.StatusBadRequest)
+		return
+	}
+
+	var req DeviceCommandRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	device, err := auth.GetDevice(id)
+	if err != nil {
+		http.Error(w, "Device not found", http.StatusNotFound)
+		return
+	}
+	if device.Status != "active" {
+		http.Error(w, "Device is not paired", http.StatusConflict)
+		return
+	}
+
+	cmd := DeviceCommand{Command: req.Command, Name: device.Name, Layout: device.Layout, Muted: device.Muted}
+	switch req.Command {
+	case "reload", "identify":
+	case "layout":
+		if err := auth.SetDeviceLayout(id, req.Layout); err != nil {
+			if errors.Is(err, auth.ErrInvalidLayout) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		cmd.Layout = req.Layout
+	case "mute":
+		if req.Muted == nil {
+			http.Error(w, "muted is required", http.StatusBadRequest)
+			return
+		}
+		if err := auth.SetDeviceMuted(id, *req.Muted); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		cmd.Muted = *req.Muted
+	case "zone":
+		if req.ZoneID == nil {
+			http.Error(w, "zone_id is required", http.StatusBadRequest)
+			return
+		}
+		if *req.ZoneID != 0 {
+			if _, err := queue.GetZone(*req.ZoneID); err != nil {
+				zoneError(w, err)
+				return
+			}
+		}
+		if err := auth.SetDeviceZone(id, *req.ZoneID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// The zone is applied when the display reconnects
+		cmd.Command = "reload"
+	default:
+		http.Error(w, "Invalid command, expected reload, identify, layout, mute or zone", http.StatusBadRequest)
+		return
+	}
+
+	audit.Record(currentIdentity(r), "device_command", 0, device.Name+": "+req.Command)
+
+	hub.Publish(handlers.EventDeviceCommand, cmd, handlers.DeviceTopic(id))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cmd)
+}
+
+// registerDevice records what a device reports over the socket and returns
+// its stored settings
+func registerDevice(identity auth.Identity, reg handlers.Registration, ip string) (interface{}, error) {
+	device, err := auth.RegisterDevice(identity.DeviceID, reg.Location, reg.Version)
+	if err != nil {
+		return nil, err
+	}
+	auth.TouchDevice(device.ID, ip)
+
+	log.Printf("Device %s registered at %q running %q", device.Name, reg.Location, reg.Version)
+	return DeviceSettings{ID: device.ID, Name: device.Name, Layout: device.Layout, Muted: device.Muted}, nil
+}
+
+// deviceHeartbeat keeps last-seen current while a device stays connected
+func deviceHeartbeat(identity auth.Identity, ip string) {
+	auth.TouchDevice(identity.DeviceID, ip)
+}
+
+// PairDeviceHandler is called by the device itself with the code shown to
+// the admin. The key is returned once and also stored as a cookie so
+// browser-based kiosks and displays authenticate automatically.
+func PairDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	var req PairDeviceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	device, key, err := auth.PairDevice(req.Code)
+	if errors.Is(err, auth.ErrInvalidPairingCode) {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Printf("Error pairing device: %v", err)
+		http.Error(w, "Database Error", http.StatusInternalServerError)
+		return
+	}
+
+	auth.TouchDevice(device.ID, clientIP(r))
+	audit.Record(device.Identity(), "device_pair", 0, clientIP(r))
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     deviceCookie,
+		Value:    key,
+		Path:     "/",
+		Expires:  time.Now().Add(deviceKeyLifetime),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"api_key": key,
+		"device":  device,
+	})
+}
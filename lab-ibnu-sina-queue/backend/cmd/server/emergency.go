@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"lab-ibnu-sina-queue/internal/audit"
+	"lab-ibnu-sina-queue/internal/handlers"
+	"lab-ibnu-sina-queue/internal/queue"
+)
+
+// =====================
+// EMERGENCY OVERRIDE
+// =====================
+
+type EmergencyRequest struct {
+	Message string `json:"message"`
+}
+
+func GetEmergencyHandler(w http.ResponseWriter, r *http.Request) {
+	e, err := queue.GetEmergency()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(e)
+}
+
+// StartEmergencyHandler puts every display and kiosk into the full-screen
+// override and stops new tickets until the all clear
+func StartEmergencyHandler(w http.ResponseWriter, r *http.Request) {
+	var req EmergencyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id := currentIdentity(r)
+	e, err := queue.StartEmergency(req.Message, id.Username)
+	if errors.Is(err, queue.ErrInvalidEmergency) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	audit.Record(id, "emergency_start", 0, e.Message)
+	log.Printf("[EMERGENCY] Started by %s: %s", id.Username, e.Message)
+
+	hub.Publish(handlers.EventEmergency, e)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(e)
+}
+
+// ClearEmergencyHandler is the all clear: displays and kiosks go back to
+// normal and tickets can be taken again
+func ClearEmergencyHandler(w http.ResponseWriter, r *http.Request) {
+	id := currentIdentity(r)
+	ended, err := queue.ClearEmergency()
+	if errors.Is(err, queue.ErrNoEmergency) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	audit.Record(id, "emergency_clear", 0, ended.Message)
+	log.Printf("[EMERGENCY] All clear given by %s", id.Username)
+
+	e := queue.Emergency{Active: false}
+	hub.Publish(handlers.EventEmergency, e)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(e)
+}
+
+// emergencyActive reports whether ticket creation is suspended. Errors
+// reading the state do not stop the kiosks.
+func emergencyActive() (queue.Emergency, bool) {
+	e, err := queue.GetEmergency()
+	if err != nil {
+		log.Printf("Error reading emergency state: %v", err)
+		return e, false
+	}
+	return e, e.Active
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"lab-ibnu-sina-queue/internal/auth"
+	"lab-ibnu-sina-queue/internal/idempotency"
+)
+
+const idempotencyHeader = "Idempotency-Key"
+
+var idempotencyStore *idempotency.Store
+
+// initIdempotency sets how long responses are kept for retries,
+// configured by IDEMPOTENCY_TTL (e.g. "10m")
+func initIdempotency() {
+	ttl := 10 * time.Minute
+	if v := os.Getenv("IDEMPOTENCY_TTL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			ttl = d
+		} else {
+			log.Printf("Invalid IDEMPOTENCY_TTL %q, using %s", v, ttl)
+		}
+	}
+	idempotencyStore = idempotency.NewStore(ttl)
+}
+
+// idempotencyScope keeps keys of different callers apart
+func idempotencyScope(id auth.Identity) string {
+	return "u" + strconv.Itoa(id.UserID) + "/d" + strconv.Itoa(id.DeviceID)
+}
+
+// responseRecorder captures a handler response while passing it through
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// idempotent replays the original response when a request is retried with
+// the same Idempotency-Key, instead of creating or mutating again. Keys are
+// scoped per caller, so it must run inside requirePermission.
+func idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		scope := idempotencyScope(currentIdentity(r))
+		sum := sha256.Sum256(append([]byte(r.Method+" "+r.URL.Path+"\n"), body...))
+		fingerprint := hex.EncodeToString(sum[:])
+
+		storeKey := scope + "/" + key
+		stored, owner, err := idempotencyStore.Begin(r.Context(), storeKey, fingerprint)
+		if errors.Is(err, idempotency.ErrKeyReused) {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		if !owner {
+			if stored.ContentType != "" {
+				w.Header().Set("Content-Type", stored.ContentType)
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(stored.Status)
+			w.Write(stored.Body)
+			return
+		}
+
+		// Release the key unless a response was stored, also when the
+		// handler panics, so retries are not refused until it expires
+		completed := false
+		defer func() {
+			if !completed {
+				idempotencyStore.Abort(storeKey)
+			}
+		}()
+
+		rec := &responseRecorder{ResponseWriter: w}
+		next(rec, r)
+
+		if rec.status == 0 || !idempotency.Cacheable(rec.status) {
+			return
+		}
+		completed = true
+		idempotencyStore.Complete(storeKey, &idempotency.Response{
+			Status:      rec.status,
+			ContentType: w.Header().Get("Content-Type"),
+			Body:        rec.body.Bytes(),
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"lab-ibnu-sina-queue/internal/auth"
+	"lab-ibnu-sina-queue/internal/broker"
+	"lab-ibnu-sina-queue/internal/handlers"
+	"lab-ibnu-sina-queue/internal/queue"
+)
+
+// =====================
+// LIVE STATE
+// =====================
+
+// initBroker picks how live events reach other server instances, configured
+// by LIVE_BROKER ("local" or "mysql") and LIVE_BROKER_POLL (e.g. "500ms")
+func initBroker() {
+	switch name := os.Getenv("LIVE_BROKER"); name {
+	case "", "local":
+	case "mysql":
+		interval := 500 * time.Millisecond
+		if v := os.Getenv("LIVE_BROKER_POLL"); v != "" {
+			if d, err := time.ParseDuration(v); err == nil && d > 0 {
+				interval = d
+			} else {
+				log.Printf("Invalid LIVE_BROKER_POLL %q, using %s", v, interval)
+			}
+		}
+		b := broker.NewMySQL(hub, interval)
+		hub.SetBroker(b)
+		go b.Run()
+		log.Printf("Sharing live events through MySQL every %s", interval)
+	default:
+		log.Printf("Invalid LIVE_BROKER %q, events stay on this instance", name)
+	}
+}
+
+// QueueSnapshot is the state sent to every live client when it connects,
+// so displays never combine stale HTTP data with live events
+type QueueSnapshot struct {
+	Counters []queue.Ticket        `json:"counters"`
+	Board    []queue.BoardEntry    `json:"board"`
+	Recent   []queue.Ticket        `json:"recent"`
+	Waiting  []queue.CategoryCount `json:"waiting"`
+	Display  queue.DisplaySettings `json:"display"`
+	Playlist []queue.PlaylistItem  `json:"playlist"`
+
+	Announcements []queue.Announcement `json:"announcements"`
+	Emergency     queue.Emergency      `json:"emergency"`
+}
+
+// queueSnapshot builds the SNAPSHOT event data for a live client
+func queueSnapshot(identity auth.Identity) (interface{}, error) {
+	filter, err := ticketFilterFor(identity)
+	if err != nil {
+		return nil, err
+	}
+
+	all, err := queue.GetCounterTickets()
+	if err != nil {
+		return nil, err
+	}
+	counters := []queue.Ticket{}
+	for _, t := range all {
+		if filter.Allows(t) {
+			counters = append(counters, t)
+		}
+	}
+
+	board, err := boardFor(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	recent, err := queue.GetRecentTickets(filter)
+	if err != nil {
+		return nil, err
+	}
+	if recent == nil {
+		recent = []queue.Ticket{}
+	}
+
+	waiting, err := queue.GetWaitingCounts(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	display, err := queue.GetDisplaySettings()
+	if err != nil {
+		return nil, err
+	}
+
+	playlist, err := queue.GetPlaylist()
+	if err != nil {
+		return nil, err
+	}
+
+	active, err := queue.ActiveAnnouncements(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	announcements := []queue.Announcement{}
+	for _, a := range active {
+		if a.For(identity.DeviceID) {
+			announcements = append(announcements, a)
+		}
+	}
+
+	emergency, err := queue.GetEmergency()
+	if err != nil {
+		return nil, err
+	}
+
+	return QueueSnapshot{
+		Counters:      counters,
+		Board:         board,
+		Recent:        recent,
+		Waiting:       waiting,
+		Display:       display,
+		Playlist:      playlist,
+		Announcements: announcements,
+		Emergency:     emergency,
+	}, nil
+}
+
+// ticketTopics returns the live topics of events about a ticket
+func ticketTopics(t queue.Ticket) []string {
+	topics := []string{handlers.CategoryTopic(t.CategoryID)}
+	if t.Counter > 0 {
+		topics = append(topics, handlers.CounterTopic(t.Counter))
+	}
+	return topics
+}
+
+// ListLiveClientsHandler shows who is connected to /ws and how well their
+// connections keep up
+func ListLiveClientsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hub.Clients())
+}
+
+// LiveStatsHandler reports hub counters such as dropped messages
+func LiveStatsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hub.Stats())
+}
@@ -1,14 +1,19 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 
 	"log"
 	"net/http"
 	"time"
 
+	"lab-ibnu-sina-queue/internal/audit"
+	"lab-ibnu-sina-queue/internal/auth"
 	"lab-ibnu-sina-queue/internal/database"
 	"lab-ibnu-sina-queue/internal/handlers"
 	"lab-ibnu-sina-queue/internal/queue"
@@ -20,40 +25,149 @@ var hub *handlers.Hub
 
 func main() {
 	// Initialize WebSocket Hub
+	handlers.SetAllowedOrigins(splitList(os.Getenv("ALLOWED_ORIGINS")))
 	hub = handlers.NewHub()
+	hub.SetSnapshot(queueSnapshot)
+	hub.SetCommandHandler(runCommand)
+	hub.SetRegisterHandler(registerDevice)
+	hub.SetHeartbeatHandler(deviceHeartbeat)
+	hub.SetZoneLookup(zoneFilter)
+	hub.SetBranch(os.Getenv("BRANCH_CODE"))
+	if policy, err := handlers.ParseOverflowPolicy(os.Getenv("WS_OVERFLOW_POLICY")); err != nil {
+		log.Printf("Invalid WS_OVERFLOW_POLICY: %v", err)
+	} else {
+		hub.SetOverflowPolicy(policy)
+	}
 	go hub.Run()
 
 	// Initialize Database
 	database.InitDB()
 
+	// Share live events with other server instances
+	initBroker()
+
+	// Kiosk abuse protection
+	initRateLimits()
+
+	// Replay responses of retried requests
+	initIdempotency()
+
+	// Counters shown on the now serving board
+	initBoard()
+
+	// Recorded clips for spoken calls
+	initVoice()
+
+	// Spacing and repeats of spoken calls
+	initCallSequencer()
+
+	// Uploaded display content
+	initMedia()
+
+	// Make sure someone can log in
+	auth.EnsureAdmin()
+	go cleanupSessions()
+
+	// Return held tickets to the queue once their hold expires
+	go releaseExpiredHolds()
+
+	// Start and expire scheduled announcements
+	go scheduleAnnouncements()
+
+	// Space the spoken calls of every counter
+	go sequenceCalls()
+
 	r := mux.NewRouter()
 
+	// =====================
+	// AUTH API Endpoints
+	// =====================
+	r.HandleFunc("/api/auth/login", LoginHandler).Methods("POST")
+	r.HandleFunc("/api/auth/logout", LogoutHandler).Methods("POST")
+	r.HandleFunc("/api/auth/me", MeHandler).Methods("GET")
+
+	r.HandleFunc("/api/users", requirePermission(auth.PermUserManage, ListUsersHandler)).Methods("GET")
+	r.HandleFunc("/api/users", requirePermission(auth.PermUserManage, CreateUserHandler)).Methods("POST")
+	r.HandleFunc("/api/users/{id:[0-9]+}", requirePermission(auth.PermUserManage, UpdateUserHandler)).Methods("PUT")
+	r.HandleFunc("/api/audit", requirePermission(auth.PermAuditView, ListAuditHandler)).Methods("GET")
+
+	// Device enrollment
+	r.HandleFunc("/api/devices/pair", limitByIP(pairLimiter, PairDeviceHandler)).Methods("POST")
+	r.HandleFunc("/api/devices", requirePermission(auth.PermDeviceManage, ListDevicesHandler)).Methods("GET")
+	r.HandleFunc("/api/devices", requirePermission(auth.PermDeviceManage, CreateDeviceHandler)).Methods("POST")
+	r.HandleFunc("/api/devices/{id:[0-9]+}/pairing-code", requirePermission(auth.PermDeviceManage, ReissuePairingCodeHandler)).Methods("POST")
+	r.HandleFunc("/api/devices/{id:[0-9]+}/revoke", requirePermission(auth.PermDeviceManage, RevokeDeviceHandler)).Methods("POST")
+	r.HandleFunc("/api/devices/{id:[0-9]+}/command", requirePermission(auth.PermDeviceManage, DeviceCommandHandler)).Methods("POST")
+	r.HandleFunc("/api/zones", requirePermission(auth.PermDeviceManage, ListZonesHandler)).Methods("GET")
+	r.HandleFunc("/api/zones", requirePermission(auth.PermDeviceManage, CreateZoneHandler)).Methods("POST")
+	r.HandleFunc("/api/zones/{id:[0-9]+}", requirePermission(auth.PermDeviceManage, UpdateZoneHandler)).Methods("PUT")
+	r.HandleFunc("/api/zones/{id:[0-9]+}", requirePermission(auth.PermDeviceManage, DeleteZoneHandler)).Methods("DELETE")
+
 	// =====================
 	// KIOSK API Endpoints
 	// =====================
-	r.HandleFunc("/api/queue/create", CreateTicketHandler).Methods("POST")
-	r.HandleFunc("/api/queue/recent", GetRecentTicketsHandler).Methods("GET")
+	r.HandleFunc("/api/queue/create", requirePermission(auth.PermTicketCreate, idempotent(CreateTicketHandler))).Methods("POST")
+	r.HandleFunc("/api/queue/recent", requirePermission(auth.PermDisplayView, GetRecentTicketsHandler)).Methods("GET")
+	r.HandleFunc("/api/board", requirePermission(auth.PermDisplayView, GetBoardHandler)).Methods("GET")
 
 	// =====================
 	// ADMIN API Endpoints
 	// =====================
-	r.HandleFunc("/api/queue/waiting", GetWaitingHandler).Methods("GET")
-	r.HandleFunc("/api/queue/stats", GetStatsHandler).Methods("GET")
-	r.HandleFunc("/api/queue/call", CallTicketHandler).Methods("POST")
-	r.HandleFunc("/api/queue/call-manual", CallManualHandler).Methods("POST")
-	r.HandleFunc("/api/queue/recall", RecallTicketHandler).Methods("POST")
-	r.HandleFunc("/api/queue/skip", SkipTicketHandler).Methods("POST")
-	r.HandleFunc("/api/queue/finish", FinishTicketHandler).Methods("POST")
-	r.HandleFunc("/api/queue/reset", ResetQueueHandler).Methods("POST")
+	r.HandleFunc("/api/queue/waiting", requirePermission(auth.PermQueueView, GetWaitingHandler)).Methods("GET")
+	r.HandleFunc("/api/queue/stats", requirePermission(auth.PermQueueView, GetStatsHandler)).Methods("GET")
+	r.HandleFunc("/api/queue/stats/timing", requirePermission(auth.PermQueueView, GetTimingStatsHandler)).Methods("GET")
+	r.HandleFunc("/api/queue/call", requirePermission(auth.PermQueueOperate, idempotent(CallTicketHandler))).Methods("POST")
+	r.HandleFunc("/api/queue/call-next", requirePermission(auth.PermQueueOperate, idempotent(CallNextHandler))).Methods("POST")
+	r.HandleFunc("/api/queue/call-manual", requirePermission(auth.PermQueueOperate, idempotent(CallManualHandler))).Methods("POST")
+	r.HandleFunc("/api/queue/recall", requirePermission(auth.PermQueueOperate, idempotent(RecallTicketHandler))).Methods("POST")
+	r.HandleFunc("/api/queue/serve", requirePermission(auth.PermQueueOperate, idempotent(ServeTicketHandler))).Methods("POST")
+	r.HandleFunc("/api/queue/skip", requirePermission(auth.PermQueueOperate, idempotent(SkipTicketHandler))).Methods("POST")
+	r.HandleFunc("/api/queue/finish", requirePermission(auth.PermQueueOperate, idempotent(FinishTicketHandler))).Methods("POST")
+	r.HandleFunc("/api/queue/held", requirePermission(auth.PermQueueView, GetHeldHandler)).Methods("GET")
+	r.HandleFunc("/api/queue/hold", requirePermission(auth.PermQueueOperate, idempotent(HoldTicketHandler))).Methods("POST")
+	r.HandleFunc("/api/queue/release", requirePermission(auth.PermQueueOperate, idempotent(ReleaseTicketHandler))).Methods("POST")
+	r.HandleFunc("/api/queue/reset", requirePermission(auth.PermQueueReset, ResetQueueHandler)).Methods("POST")
+	r.HandleFunc("/api/queue/void", requirePermission(auth.PermQueueReset, VoidTicketsHandler)).Methods("POST")
 
 	// Display Settings
-	r.HandleFunc("/api/display/video", UpdateVideoHandler).Methods("POST")
-	r.HandleFunc("/api/display/video", GetVideoHandler).Methods("GET")
+	r.HandleFunc("/api/display/video", requirePermission(auth.PermDisplayManage, UpdateVideoHandler)).Methods("POST")
+	r.HandleFunc("/api/display/video", requirePermission(auth.PermDisplayView, GetVideoHandler)).Methods("GET")
+	r.HandleFunc("/api/display/playlist", requirePermission(auth.PermDisplayView, GetPlaylistHandler)).Methods("GET")
+	r.HandleFunc("/api/display/playlist", requirePermission(auth.PermDisplayManage, CreatePlaylistItemHandler)).Methods("POST")
+	r.HandleFunc("/api/display/playlist/order", requirePermission(auth.PermDisplayManage, ReorderPlaylistHandler)).Methods("POST")
+	r.HandleFunc("/api/display/playlist/{id:[0-9]+}", requirePermission(auth.PermDisplayManage, UpdatePlaylistItemHandler)).Methods("PUT")
+	r.HandleFunc("/api/display/playlist/{id:[0-9]+}", requirePermission(auth.PermDisplayManage, DeletePlaylistItemHandler)).Methods("DELETE")
+
+	// Announcements
+	r.HandleFunc("/api/announcements", requirePermission(auth.PermDisplayManage, ListAnnouncementsHandler)).Methods("GET")
+	r.HandleFunc("/api/announcements", requirePermission(auth.PermDisplayManage, CreateAnnouncementHandler)).Methods("POST")
+	r.HandleFunc("/api/announcements/{id:[0-9]+}", requirePermission(auth.PermDisplayManage, UpdateAnnouncementHandler)).Methods("PUT")
+	r.HandleFunc("/api/announcements/{id:[0-9]+}", requirePermission(auth.PermDisplayManage, DeleteAnnouncementHandler)).Methods("DELETE")
+
+	// Emergency override
+	r.HandleFunc("/api/emergency", requirePermission(auth.PermLiveSubscribe, GetEmergencyHandler)).Methods("GET")
+	r.HandleFunc("/api/emergency", requirePermission(auth.PermEmergency, StartEmergencyHandler)).Methods("POST")
+	r.HandleFunc("/api/emergency/clear", requirePermission(auth.PermEmergency, ClearEmergencyHandler)).Methods("POST")
+
+	// Media Library
+	r.HandleFunc("/api/media", requirePermission(auth.PermDisplayManage, ListMediaHandler)).Methods("GET")
+	r.HandleFunc("/api/media", requirePermission(auth.PermDisplayManage, UploadMediaHandler)).Methods("POST")
+	r.HandleFunc("/api/media/{id:[0-9]+}", requirePermission(auth.PermDisplayManage, DeleteMediaHandler)).Methods("DELETE")
+	r.HandleFunc("/media/{name}", requirePermission(auth.PermDisplayView, ServeMediaHandler)).Methods("GET", "HEAD")
+
+	// Spoken call announcements
+	r.HandleFunc("/voice/{code}/{counter:[0-9]+}.wav", requirePermission(auth.PermDisplayView, ServeVoiceHandler)).Methods("GET", "HEAD")
 
 	// WebSocket Endpoint
-	r.HandleFunc("/ws", func(w http.ResponseWriter, req *http.Request) {
-		handlers.ServeWs(hub, w, req)
-	})
+	r.HandleFunc("/ws", requirePermission(auth.PermLiveSubscribe, func(w http.ResponseWriter, req *http.Request) {
+		handlers.ServeWs(hub, w, req, currentIdentity(req), clientIP(req))
+	}))
+	// Server-Sent Events fallback for networks blocking WebSockets
+	r.HandleFunc("/api/events", requirePermission(auth.PermLiveSubscribe, func(w http.ResponseWriter, req *http.Request) {
+		handlers.ServeSSE(hub, w, req, currentIdentity(req), clientIP(req))
+	})).Methods("GET")
+	r.HandleFunc("/api/ws/clients", requirePermission(auth.PermDeviceManage, ListLiveClientsHandler)).Methods("GET")
+	r.HandleFunc("/api/ws/stats", requirePermission(auth.PermDeviceManage, LiveStatsHandler)).Methods("GET")
 
 	// =====================
 	// Serve Static Files
@@ -66,6 +180,7 @@ func main() {
 	r.PathPrefix("/kiosk/").Handler(http.StripPrefix("/kiosk/", http.FileServer(http.Dir(staticDir+"/kiosk"))))
 	r.PathPrefix("/display/").Handler(http.StripPrefix("/display/", http.FileServer(http.Dir(staticDir+"/display"))))
 	r.PathPrefix("/admin/").Handler(http.StripPrefix("/admin/", http.FileServer(http.Dir(staticDir+"/admin"))))
+	r.PathPrefix("/login/").Handler(http.StripPrefix("/login/", http.FileServer(http.Dir(staticDir+"/login"))))
 	r.PathPrefix("/shared/").Handler(http.StripPrefix("/shared/", http.FileServer(http.Dir(staticDir+"/shared"))))
 
 	// Default redirect to kiosk
@@ -102,12 +217,21 @@ type CallTicketRequest struct {
 	Counter  int `json:"counter"`
 }
 
+type CallNextRequest struct {
+	Counter    int `json:"counter"`
+	CategoryID int `json:"category_id"`
+}
+
 type TicketIDRequest struct {
 	TicketID int `json:"ticket_id"`
 }
 
-// Store last called ticket per counter for recall
-var lastCalledTickets = make(map[int]queue.Ticket)
+type HoldTicketRequest struct {
+	TicketID int        `json:"ticket_id"`
+	Reason   string     `json:"reason"`
+	Until    *time.Time `json:"until"`
+	Minutes  int        `json:"minutes"`
+}
 
 // =====================
 // KIOSK HANDLERS
@@ -120,27 +244,63 @@ func CreateTicketHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ticket, err := queue.GenerateTicket(req.CategoryID)
+	if e, active := emergencyActive(); active {
+		http.Error(w, "Ticket creation is suspended: "+e.Message, http.StatusServiceUnavailable)
+		return
+	}
+
+	id := currentIdentity(r)
+	ip := clientIP(r)
+	if ok, wait := allowTicketCreation(id, ip); !ok {
+		writeRateLimited(w, id, ip, wait)
+		return
+	}
+
+	ticket, err := queue.GenerateTicket(req.CategoryID, queue.TicketSource{DeviceID: id.DeviceID, IP: ip})
 	if err != nil {
 		log.Printf("Error creating ticket: %v", err)
 		http.Error(w, "Database Error", http.StatusInternalServerError)
 		return
 	}
 
+	audit.Record(id, "create", ticket.ID, ticket.FormattedCode)
+
 	fmt.Printf("[PRINTER] Printing ticket: %s\n", ticket.FormattedCode)
 
-	msg, _ := json.Marshal(map[string]interface{}{
-		"type": "NEW_TICKET",
-		"data": ticket,
-	})
-	hub.BroadcastMessage(msg)
+	hub.Publish(handlers.EventNewTicket, ticket, ticketTopics(ticket)...)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(ticket)
 }
 
+// GetRecentTicketsHandler returns the latest tickets of the caller's zone.
+// The category and counter parameters (e.g. ?counter=1,2) narrow the list
+// within the zone; asking for tickets outside it returns none.
 func GetRecentTicketsHandler(w http.ResponseWriter, r *http.Request) {
-	tickets, err := queue.GetRecentTickets()
+	filter, err := ticketFilterFor(currentIdentity(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var categories, counters []int
+	query := r.URL.Query()
+	if query.Has("category") {
+		if categories, err = parseIDList(query.Get("category")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if query.Has("counter") {
+		if counters, err = parseIDList(query.Get("counter")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	tickets := []queue.Ticket{}
+	if filter, ok := filter.Narrow(categories, counters); ok {
+		tickets, err = queue.GetRecentTickets(filter)
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -173,6 +333,26 @@ func GetStatsHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(stats)
 }
 
+func GetTimingStatsHandler(w http.ResponseWriter, r *http.Request) {
+	day := time.Now()
+	if date := r.URL.Query().Get("date"); date != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", date, time.Local)
+		if err != nil {
+			http.Error(w, "Invalid date, expected YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		day = parsed
+	}
+
+	report, err := queue.GetTimingReport(day)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
 func CallManualHandler(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Code    string `json:"code"`
@@ -191,24 +371,12 @@ func CallManualHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Call it
-	ticket, err := queue.CallTicket(t.ID, req.Counter)
+	ticket, err := callTicket(currentIdentity(r), "call_manual", t.ID, req.Counter)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), actionStatus(err))
 		return
 	}
 
-	// Store for recall
-	lastCalledTickets[req.Counter] = ticket
-
-	fmt.Printf("[MANUAL CALL] Calling ticket %s to Counter %d\n", ticket.FormattedCode, req.Counter)
-
-	// Broadcast to display
-	msg, _ := json.Marshal(map[string]interface{}{
-		"type": "CALL_TICKET",
-		"data": ticket,
-	})
-	hub.BroadcastMessage(msg)
-
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(ticket)
 }
@@ -220,23 +388,32 @@ func CallTicketHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ticket, err := queue.CallTicket(req.TicketID, req.Counter)
+	ticket, err := callTicket(currentIdentity(r), "call", req.TicketID, req.Counter)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), actionStatus(err))
 		return
 	}
 
-	// Store for recall
-	lastCalledTickets[req.Counter] = ticket
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ticket)
+}
 
-	fmt.Printf("[CALL] Calling ticket %s to Counter %d\n", ticket.FormattedCode, req.Counter)
+func CallNextHandler(w http.ResponseWriter, r *http.Request) {
+	var req CallNextRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	// Broadcast to display
-	msg, _ := json.Marshal(map[string]interface{}{
-		"type": "CALL_TICKET",
-		"data": ticket,
-	})
-	hub.BroadcastMessage(msg)
+	ticket, err := callNext(currentIdentity(r), req.Counter, req.CategoryID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "No ticket waiting", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(ticket)
@@ -251,20 +428,36 @@ func RecallTicketHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ticket, exists := lastCalledTickets[req.Counter]
-	if !exists {
+	ticket, err := recallTicket(currentIdentity(r), req.Counter)
+	if errors.Is(err, sql.ErrNoRows) {
 		http.Error(w, "No ticket to recall", http.StatusNotFound)
 		return
 	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-	fmt.Printf("[RECALL] Recalling ticket %s to Counter %d\n", ticket.FormattedCode, req.Counter)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ticket)
+}
 
-	// Broadcast recall
-	msg, _ := json.Marshal(map[string]interface{}{
-		"type": "CALL_TICKET",
-		"data": ticket,
-	})
-	hub.BroadcastMessage(msg)
+func ServeTicketHandler(w http.ResponseWriter, r *http.Request) {
+	var req TicketIDRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ticket, err := serveTicket(currentIdentity(r), req.TicketID)
+	if errors.Is(err, queue.ErrInvalidTransition) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(ticket)
@@ -277,9 +470,8 @@ func SkipTicketHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := queue.SkipTicket(req.TicketID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := skipTicket(currentIdentity(r), req.TicketID); err != nil {
+		http.Error(w, err.Error(), actionStatus(err))
 		return
 	}
 
@@ -294,14 +486,114 @@ func FinishTicketHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := queue.FinishTicket(req.TicketID)
+	if err := finishTicket(currentIdentity(r), req.TicketID); err != nil {
+		http.Error(w, err.Error(), actionStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "finished"})
+}
+
+func GetHeldHandler(w http.ResponseWriter, r *http.Request) {
+	tickets, err := queue.GetHeldTickets()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tickets)
+}
+
+func HoldTicketHandler(w http.ResponseWriter, r *http.Request) {
+	var req HoldTicketRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Minutes is a shortcut for an absolute reactivation time
+	until := req.Until
+	if until == nil && req.Minutes > 0 {
+		t := time.Now().Add(time.Duration(req.Minutes) * time.Minute)
+		until = &t
+	}
+
+	ticket, err := queue.HoldTicket(req.TicketID, req.Reason, until)
+	if errors.Is(err, queue.ErrInvalidTransition) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	audit.Record(currentIdentity(r), "hold", ticket.ID, req.Reason)
+
+	fmt.Printf("[HOLD] Holding ticket %s: %s\n", ticket.FormattedCode, req.Reason)
+
+	hub.Publish(handlers.EventHoldTicket, ticket, ticketTopics(ticket)...)
+	publishBoard(ticket.Counter)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ticket)
+}
+
+func ReleaseTicketHandler(w http.ResponseWriter, r *http.Request) {
+	var req TicketIDRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Releasing clears the counter, so note where the ticket was held
+	held, _ := queue.GetTicket(req.TicketID)
+
+	ticket, err := queue.ReleaseTicket(req.TicketID)
+	if errors.Is(err, queue.ErrInvalidTransition) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	audit.Record(currentIdentity(r), "release", ticket.ID, ticket.FormattedCode)
+
+	fmt.Printf("[RELEASE] Ticket %s back to waiting\n", ticket.FormattedCode)
+
+	hub.Publish(handlers.EventReleaseTicket, ticket, ticketTopics(ticket)...)
+	publishBoard(held.Counter)
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "finished"})
+	json.NewEncoder(w).Encode(ticket)
+}
+
+// releaseExpiredHolds periodically puts tickets whose hold has expired back
+// into the waiting list
+func releaseExpiredHolds() {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		tickets, err := queue.ReleaseExpiredHolds(time.Now())
+		if err != nil {
+			log.Printf("Error releasing expired holds: %v", err)
+			continue
+		}
+
+		for _, ticket := range tickets {
+			fmt.Printf("[RELEASE] Hold expired for ticket %s\n", ticket.FormattedCode)
+
+			hub.Publish(handlers.EventReleaseTicket, ticket, ticketTopics(ticket)...)
+		}
+		// The released tickets no longer say which counter held them
+		if len(tickets) > 0 {
+			publishBoards()
+		}
+	}
 }
 
 func ResetQueueHandler(w http.ResponseWriter, r *http.Request) {
@@ -311,11 +603,11 @@ func ResetQueueHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	audit.Record(currentIdentity(r), "reset", 0, "")
+
 	// Broadcast reset
-	msg, _ := json.Marshal(map[string]interface{}{
-		"type": "RESET_QUEUE",
-	})
-	hub.BroadcastMessage(msg)
+	hub.Publish(handlers.EventResetQueue, nil)
+	publishBoards()
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"status": "reset"})
@@ -334,12 +626,10 @@ func UpdateVideoHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	audit.Record(currentIdentity(r), "display_update", 0, req.VideoURL)
+
 	// Broadcast to display
-	msg, _ := json.Marshal(map[string]interface{}{
-		"type": "UPDATE_VIDEO",
-		"data": req,
-	})
-	hub.BroadcastMessage(msg)
+	hub.Publish(handlers.EventUpdateVideo, req)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"status": "updated"})
@@ -357,3 +647,14 @@ func GetVideoHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(settings)
 }
+
+// splitList parses a comma separated environment value
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
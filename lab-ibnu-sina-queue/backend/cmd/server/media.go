@@ -0,0 +1,171 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"lab-ibnu-sina-queue/internal/audit"
+	"lab-ibnu-sina-queue/internal/media"
+	"lab-ibnu-sina-queue/internal/queue"
+
+	"github.com/gorilla/mux"
+)
+
+// =====================
+// MEDIA LIBRARY
+// =====================
+
+// uploadTimeout is how long a single upload may take; the server's own
+// timeouts are far too short for videos
+const uploadTimeout = 30 * time.Minute
+
+var mediaLibrary *media.Library
+
+// initMedia sets up storage for uploaded display content, configured by
+// MEDIA_PATH (default "./media") and MEDIA_MAX_MB (default 500). With
+// several server instances MEDIA_PATH must be shared storage.
+func initMedia() {
+	dir := os.Getenv("MEDIA_PATH")
+	if dir == "" {
+		dir = "./media"
+	}
+
+	maxMB := 500
+	if v := os.Getenv("MEDIA_MAX_MB"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			maxMB = n
+		} else {
+			log.Printf("Invalid MEDIA_MAX_MB %q, using %d", v, maxMB)
+		}
+	}
+
+	library, err := media.NewLibrary(dir, int64(maxMB)<<20)
+	if err != nil {
+		log.Fatalf("Could not create media directory %s: %v", dir, err)
+	}
+	mediaLibrary = library
+}
+
+func ListMediaHandler(w http.ResponseWriter, r *http.Request) {
+	files, err := media.List()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(files)
+}
+
+// UploadMediaHandler stores the "file" field of a multipart upload. The
+// file is streamed to disk, never held in memory.
+func UploadMediaHandler(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Now().Add(uploadTimeout))
+	rc.SetWriteDeadline(time.Now().Add(uploadTimeout))
+
+	// Leave room for the multipart headers
+	r.Body = http.MaxBytesReader(w, r.Body, mediaLibrary.MaxSize()+1<<20)
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			http.Error(w, "Missing file field", http.StatusBadRequest)
+			return
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+
+		file, err := mediaLibrary.Save(part.FileName(), part, currentIdentity(r).Username)
+		var maxBytesErr *http.MaxBytesError
+		switch {
+		case errors.Is(err, media.ErrUnsupportedType):
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			return
+		case errors.Is(err, media.ErrTooLarge), errors.As(err, &maxBytesErr):
+			http.Error(w, media.ErrTooLarge.Error(), http.StatusRequestEntityTooLarge)
+			return
+		case err != nil:
+			log.Printf("Error saving media: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		audit.Record(currentIdentity(r), "media_upload", 0, file.Name+" ("+file.URL+")")
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(file)
+		return
+	}
+}
+
+// DeleteMediaHandler removes a file unless the displays still show it
+func DeleteMediaHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid media ID", http.StatusBadRequest)
+		return
+	}
+
+	file, err := media.Get(id)
+	if err != nil {
+		http.Error(w, "Media not found", http.StatusNotFound)
+		return
+	}
+
+	inUse, err := queue.MediaInUse(file.URL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if inUse {
+		http.Error(w, "Media is still used by the display settings or playlist", http.StatusConflict)
+		return
+	}
+
+	if err := mediaLibrary.Delete(id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	audit.Record(currentIdentity(r), "media_delete", 0, file.Name+" ("+file.URL+")")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
+}
+
+// ServeMediaHandler serves a stored file with range requests, so videos
+// can seek and resume. Stored names are content hashes, so the file behind
+// a URL never changes and displays may cache it for good.
+func ServeMediaHandler(w http.ResponseWriter, r *http.Request) {
+	f, file, err := mediaLibrary.Open(mux.Vars(r)["name"])
+	if errors.Is(err, sql.ErrNoRows) || errors.Is(err, os.ErrNotExist) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	// Large videos take longer than the server's write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", file.ContentType)
+	w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	w.Header().Set("ETag", `"`+file.StoredName+`"`)
+	http.ServeContent(w, r, file.StoredName, file.CreatedAt, f)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"lab-ibnu-sina-queue/internal/audit"
+	"lab-ibnu-sina-queue/internal/handlers"
+	"lab-ibnu-sina-queue/internal/queue"
+
+	"github.com/gorilla/mux"
+)
+
+// =====================
+// DISPLAY PLAYLIST
+// =====================
+
+type ReorderPlaylistRequest struct {
+	IDs []int `json:"ids"`
+}
+
+// publishPlaylist sends the whole playlist to the displays after a change,
+// so they switch content without reloading
+func publishPlaylist() {
+	playlist, err := queue.GetPlaylist()
+	if err != nil {
+		log.Printf("Error loading playlist: %v", err)
+		return
+	}
+	hub.Publish(handlers.EventUpdatePlaylist, playlist)
+}
+
+// playlistError maps a playlist store error to an HTTP response
+func playlistError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, queue.ErrInvalidPlaylistItem):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Playlist item not found", http.StatusNotFound)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func GetPlaylistHandler(w http.ResponseWriter, r *http.Request) {
+	playlist, err := queue.GetPlaylist()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(playlist)
+}
+
+func CreatePlaylistItemHandler(w http.ResponseWriter, r *http.Request) {
+	item := queue.PlaylistItem{Enabled: true}
+	if err := json.NewDecoder(r.Body).Decode(&item); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	item, err := queue.CreatePlaylistItem(item)
+	if err != nil {
+		playlistError(w, err)
+		return
+	}
+
+	audit.Record(currentIdentity(r), "playlist_create", 0, item.Kind+" "+item.Title)
+	publishPlaylist()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(item)
+}
+
+func UpdatePlaylistItemHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid playlist item ID", http.StatusBadRequest)
+		return
+	}
+
+	var item queue.PlaylistItem
+	if err := json.NewDecoder(r.Body).Decode(&item); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	item.ID = id
+
+	item, err = queue.UpdatePlaylistItem(item)
+	if err != nil {
+		playlistError(w, err)
+		return
+	}
+
+	audit.Record(currentIdentity(r), "playlist_update", 0, item.Kind+" "+item.Title)
+	publishPlaylist()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(item)
+}
+
+func DeletePlaylistItemHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid playlist item ID", http.StatusBadRequest)
+		return
+	}
+
+	item, err := queue.GetPlaylistItem(id)
+	if err != nil {
+		playlistError(w, err)
+		return
+	}
+	if err := queue.DeletePlaylistItem(id); err != nil {
+		playlistError(w, err)
+		return
+	}
+
+	audit.Record(currentIdentity(r), "playlist_delete", 0, item.Kind+" "+item.Title)
+	publishPlaylist()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
+}
+
+func ReorderPlaylistHandler(w http.ResponseWriter, r *http.Request) {
+	var req ReorderPlaylistRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := queue.ReorderPlaylist(req.IDs); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	audit.Record(currentIdentity(r), "playlist_reorder", 0, "")
+	publishPlaylist()
+
+	playlist, err := queue.GetPlaylist()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(playlist)
+}
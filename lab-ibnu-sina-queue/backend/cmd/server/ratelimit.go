@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"lab-ibnu-sina-queue/internal/audit"
+	"lab-ibnu-sina-queue/internal/auth"
+	"lab-ibnu-sina-queue/internal/handlers"
+	"lab-ibnu-sina-queue/internal/queue"
+	"lab-ibnu-sina-queue/internal/ratelimit"
+)
+
+// Default ticket creation limits for kiosks, overridable with
+// TICKET_RATE_DEVICE and TICKET_RATE_IP ("limit/window,...")
+const (
+	defaultDeviceRateRules = "3/30s,15/10m"
+	defaultIPRateRules     = "5/30s,30/10m"
+
+	// Pairing attempts per address, overridable with PAIR_RATE_IP
+	defaultPairRateRules = "5/1m,20/1h"
+
+	// burstAlertInterval limits SUSPICIOUS_BURST events per source
+	burstAlertInterval = time.Minute
+)
+
+var (
+	deviceLimiter *ratelimit.Limiter
+	ipLimiter     *ratelimit.Limiter
+	pairLimiter   *ratelimit.Limiter
+
+	burstAlertMu    sync.Mutex
+	burstAlerts     = make(map[string]time.Time)
+	burstAlertSweep time.Time
+)
+
+// BurstAlert tells staff a kiosk or address hit the ticket rate limit
+type BurstAlert struct {
+	DeviceID   int       `json:"device_id,omitempty"`
+	DeviceName string    `json:"device_name,omitempty"`
+	IP         string    `json:"ip"`
+	RetryAfter int       `json:"retry_after"`
+	At         time.Time `json:"at"`
+}
+
+type VoidTicketsRequest struct {
+	TicketIDs []int      `json:"ticket_ids"`
+	DeviceID  int        `json:"device_id"`
+	IP        string     `json:"ip"`
+	Since     *time.Time `json:"since"`
+	Until     *time.Time `json:"until"`
+}
+
+func initRateLimits() {
+	deviceLimiter = ratelimit.New(loadRateRules("TICKET_RATE_DEVICE", defaultDeviceRateRules))
+	ipLimiter = ratelimit.New(loadRateRules("TICKET_RATE_IP", defaultIPRateRules))
+	pairLimiter = ratelimit.New(loadRateRules("PAIR_RATE_IP", defaultPairRateRules))
+}
+
+// limitByIP rejects requests from an address that exceeded the limiter's
+// rules, for endpoints open to anyone such as device pairing
+func limitByIP(l *ratelimit.Limiter, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ok, wait := l.Allow("ip:"+clientIP(r), time.Now()); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "Too many attempts, try again later", http.StatusTooManyRequests)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func loadRateRules(env, fallback string) []ratelimit.Rule {
+	spec := os.Getenv(env)
+	if spec == "" {
+		spec = fallback
+	}
+
+	rules, err := ratelimit.ParseRules(spec)
+	if err != nil {
+		log.Printf("Invalid %s (%v), using %s", env, err, fallback)
+		rules, _ = ratelimit.ParseRules(fallback)
+	}
+	return rules
+}
+
+// allowTicketCreation applies the kiosk rate limits. Staff creating tickets
+// from the admin panel are not limited.
+func allowTicketCreation(id auth.Identity, ip string) (bool, time.Duration) {
+	if id.Role != auth.RoleKiosk {
+		return true, 0
+	}
+
+	now := time.Now()
+	ok, wait := ipLimiter.Allow("ip:"+ip, now)
+	if !ok {
+		return false, wait
+	}
+
+	key := "user:" + strconv.Itoa(id.UserID)
+	if id.DeviceID > 0 {
+		key = "device:" + strconv.Itoa(id.DeviceID)
+	}
+	return deviceLimiter.Allow(key, now)
+}
+
+// writeRateLimited answers with a cooldown the kiosk can show and alerts staff
+func writeRateLimited(w http.ResponseWriter, id auth.Identity, ip string, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+
+	alertBurst(BurstAlert{
+		DeviceID:   id.DeviceID,
+		DeviceName: id.DisplayName,
+		IP:         ip,
+		RetryAfter: seconds,
+		At:         time.Now(),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":       "rate_limited",
+		"message":     fmt.Sprintf("Mohon tunggu %d detik sebelum mengambil antrian lagi", seconds),
+		"retry_after": seconds,
+	})
+}
+
+// sweepBurstAlerts forgets sources whose last alert is older than
+// burstAlertInterval, at most once per interval. Called with burstAlertMu
+// held.
+func sweepBurstAlerts(now time.Time) {
+	if now.Sub(burstAlertSweep) < burstAlertInterval {
+		return
+	}
+	burstAlertSweep = now
+
+	for key, at := range burstAlerts {
+		if now.Sub(at) >= burstAlertInterval {
+			delete(burstAlerts, key)
+		}
+	}
+}
+
+func alertBurst(alert BurstAlert) {
+	key := alert.IP + "/" + strconv.Itoa(alert.DeviceID)
+
+	now := time.Now()
+	burstAlertMu.Lock()
+	sweepBurstAlerts(now)
+	if now.Sub(burstAlerts[key]) < burstAlertInterval {
+		burstAlertMu.Unlock()
+		return
+	}
+	burstAlerts[key] = now
+	burstAlertMu.Unlock()
+
+	fmt.Printf("[RATE LIMIT] Ticket burst from %s (device %d)\n", alert.IP, alert.DeviceID)
+
+	hub.Publish(handlers.EventSuspiciousBurst, alert, handlers.TopicAdmin)
+}
+
+func VoidTicketsHandler(w http.ResponseWriter, r *http.Request) {
+	var req VoidTicketsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Default to the last half hour, the typical size of a burst
+	filter := queue.VoidFilter{
+		TicketIDs: req.TicketIDs,
+		DeviceID:  req.DeviceID,
+		IP:        req.IP,
+		Since:     time.Now().Add(-30 * time.Minute),
+		Until:     time.Now(),
+	}
+	if req.Since != nil {
+		filter.Since = *req.Since
+	}
+	if req.Until != nil {
+		filter.Until = *req.Until
+	}
+	if filter.Since.IsZero() || filter.Until.IsZero() {
+		http.Error(w, "since and until must be set", http.StatusBadRequest)
+		return
+	}
+	if filter.Until.Before(filter.Since) {
+		http.Error(w, "until is before since", http.StatusBadRequest)
+		return
+	}
+
+	tickets, err := queue.VoidTickets(filter)
+	if errors.Is(err, queue.ErrEmptyVoidFilter) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for _, t := range tickets {
+		audit.Record(currentIdentity(r), "void", t.ID, t.FormattedCode)
+	}
+
+	fmt.Printf("[VOID] Voided %d tickets\n", len(tickets))
+
+	publishVoided(tickets)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tickets)
+}
+
+// publishVoided tells staff and displays which tickets were voided, one
+// event per category so displays limited to a zone get theirs
+func publishVoided(tickets []queue.Ticket) {
+	var categories []int
+	byCategory := make(map[int][]queue.Ticket)
+	for _, t := range tickets {
+		if _, ok := byCategory[t.CategoryID]; !ok {
+			categories = append(categories, t.CategoryID)
+		}
+		byCategory[t.CategoryID] = append(byCategory[t.CategoryID], t)
+	}
+
+	for _, id := range categories {
+		hub.Publish(handlers.EventTicketsVoided, byCategory[id], handlers.TopicAdmin, handlers.CategoryTopic(id))
+	}
+}
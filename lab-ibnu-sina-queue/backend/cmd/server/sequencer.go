@@ -0,0 +1,135 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"lab-ibnu-sina-queue/internal/handlers"
+	"lab-ibnu-sina-queue/internal/queue"
+)
+
+// =====================
+// CALL SEQUENCING
+// =====================
+
+// Spoken calls are spaced by callSpacing and each is repeated callRepeats
+// times; see initCallSequencer
+var (
+	callSpacing = 1500 * time.Millisecond
+	callRepeats = 1
+)
+
+// callQueue holds the calls waiting for sequenceCalls, so counter actions
+// never wait for the schedule's row lock
+var callQueue = make(chan queuedCall, 256)
+
+// queuedCall is a call or recall waiting to be scheduled
+type queuedCall struct {
+	kind string
+	call CallAnnouncement
+}
+
+const (
+	// chimeLength is how long displays play their chime before a call
+	chimeLength = time.Second
+
+	// speechLength is the time allowed for a call spoken by a display's
+	// speech synthesis, which the server cannot measure
+	speechLength = 5 * time.Second
+
+	// announceLead gives displays time to receive a call and fetch its
+	// audio before it is due
+	announceLead = time.Second
+)
+
+// Announce is the data of ANNOUNCE events: one playback of a call or
+// recall, due at PlayAt and taking LengthMS. Repeat counts from 1 to
+// Repeats.
+type Announce struct {
+	CallAnnouncement
+	Kind     string    `json:"kind"`
+	PlayAt   time.Time `json:"play_at"`
+	LengthMS int64     `json:"length_ms"`
+	Repeat   int       `json:"repeat"`
+	Repeats  int       `json:"repeats"`
+}
+
+// initCallSequencer reads the silence between calls from CALL_SPACING_MS
+// (default 1500) and how often each call is spoken from CALL_REPEATS
+// (default 1)
+func initCallSequencer() {
+	if v := os.Getenv("CALL_SPACING_MS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			callSpacing = time.Duration(n) * time.Millisecond
+		} else {
+			log.Printf("Invalid CALL_SPACING_MS %q, using %s", v, callSpacing)
+		}
+	}
+	if v := os.Getenv("CALL_REPEATS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 1 && n <= 5 {
+			callRepeats = n
+		} else {
+			log.Printf("Invalid CALL_REPEATS %q, using %d", v, callRepeats)
+		}
+	}
+}
+
+// callLength is how long displays take to play an announcement
+func callLength(a CallAnnouncement) time.Duration {
+	if a.AudioURL == "" {
+		return chimeLength + speechLength
+	}
+	audio, err := voiceLibrary.Call(a.FormattedCode, a.Counter)
+	if err != nil {
+		return chimeLength + speechLength
+	}
+	return chimeLength + voiceLibrary.Length(audio)
+}
+
+// announceCall queues a call or recall ("call" or "recall") to be spoken.
+// It never blocks; calls made while the queue is full are shown but not
+// spoken.
+func announceCall(kind string, a CallAnnouncement) {
+	select {
+	case callQueue <- queuedCall{kind: kind, call: a}:
+	default:
+		log.Printf("Announcement queue full, %s not spoken", a.FormattedCode)
+	}
+}
+
+// sequenceCalls schedules queued calls one at a time, in the order they
+// were made
+func sequenceCalls() {
+	for q := range callQueue {
+		scheduleCall(q.kind, q.call)
+	}
+}
+
+// scheduleCall books the next free slots for a call and tells the displays
+// when to speak it. Calls are not spoken over the emergency override.
+func scheduleCall(kind string, a CallAnnouncement) {
+	if _, active := emergencyActive(); active {
+		return
+	}
+
+	length := callLength(a)
+	slots, err := queue.ReserveCallSlots(time.Now().Add(announceLead), length, callSpacing, callRepeats)
+	if err != nil {
+		// Speaking right away beats not speaking at all
+		log.Printf("Error scheduling announcement of %s: %v", a.FormattedCode, err)
+		slots = []time.Time{time.Now()}
+	}
+
+	for i, at := range slots {
+		hub.Publish(handlers.EventAnnounce, Announce{
+			CallAnnouncement: a,
+			Kind:             kind,
+			PlayAt:           at,
+			LengthMS:         length.Milliseconds(),
+			Repeat:           i + 1,
+			Repeats:          len(slots),
+		}, ticketTopics(a.Ticket)...)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"lab-ibnu-sina-queue/internal/queue"
+	"lab-ibnu-sina-queue/internal/voice"
+
+	"github.com/gorilla/mux"
+)
+
+// =====================
+// VOICE ANNOUNCEMENTS
+// =====================
+
+// voiceLibrary is nil unless VOICE_PATH is set; displays then fall back to
+// their browser's speech synthesis
+var voiceLibrary *voice.Library
+
+// ticketCodePattern matches the ticket codes calls can be assembled for
+var ticketCodePattern = regexp.MustCompile(`^[A-Z]{1,3}-[0-9]{1,5}$`)
+
+// initVoice loads the recorded clips of VOICE_PATH, a directory of WAV
+// files in one format, and the silence between the ticket and the counter
+// from VOICE_PAUSE_MS (default 300)
+func initVoice() {
+	dir := os.Getenv("VOICE_PATH")
+	if dir == "" {
+		return
+	}
+
+	pause := 300 * time.Millisecond
+	if v := os.Getenv("VOICE_PAUSE_MS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			pause = time.Duration(n) * time.Millisecond
+		} else {
+			log.Printf("Invalid VOICE_PAUSE_MS %q, using %s", v, pause)
+		}
+	}
+
+	library, err := voice.NewLibrary(dir, pause)
+	if err != nil {
+		log.Printf("Voice clips not loaded, displays use speech synthesis: %v", err)
+		return
+	}
+	voiceLibrary = library
+	log.Printf("Loaded %d voice clips from %s (%s)", library.Len(), dir, library.Format())
+}
+
+// CallAnnouncement is the data of CALL_TICKET and RECALL_TICKET events:
+// the ticket and, when voice clips are configured, the URL of its spoken
+// announcement
+type CallAnnouncement struct {
+	queue.Ticket
+	AudioURL string `json:"audio_url,omitempty"`
+}
+
+// callAnnouncement prepares the announcement of a call. Calls that cannot
+// be assembled, e.g. because a clip is missing, get no URL.
+func callAnnouncement(t queue.Ticket) CallAnnouncement {
+	a := CallAnnouncement{Ticket: t}
+	if voiceLibrary == nil || !ticketCodePattern.MatchString(t.FormattedCode) {
+		return a
+	}
+
+	// Assembling now fills the cache before displays ask for it
+	if _, err := voiceLibrary.Call(t.FormattedCode, t.Counter); err != nil {
+		log.Printf("Error assembling announcement of %s: %v", t.FormattedCode, err)
+		return a
+	}
+	a.AudioURL = fmt.Sprintf("/voice/%s/%d.wav", t.FormattedCode, t.Counter)
+	return a
+}
+
+// ServeVoiceHandler serves the assembled announcement of a ticket code
+// and counter
+func ServeVoiceHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	counter, err := strconv.Atoi(vars["counter"])
+	if voiceLibrary == nil || err != nil || !ticketCodePattern.MatchString(vars["code"]) {
+		http.NotFound(w, r)
+		return
+	}
+
+	audio, err := voiceLibrary.Call(vars["code"], counter)
+	if errors.Is(err, voice.ErrMissingClip) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "audio/wav")
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	http.ServeContent(w, r, vars["code"]+".wav", voiceLibrary.LoadedAt(), bytes.NewReader(audio))
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"lab-ibnu-sina-queue/internal/audit"
+	"lab-ibnu-sina-queue/internal/auth"
+	"lab-ibnu-sina-queue/internal/handlers"
+	"lab-ibnu-sina-queue/internal/queue"
+
+	"github.com/gorilla/mux"
+)
+
+// =====================
+// DISPLAY ZONES
+// =====================
+
+// ticketFilterFor returns the filter of a paired display's zone. Staff and
+// displays without a zone see every ticket.
+func ticketFilterFor(identity auth.Identity) (queue.TicketFilter, error) {
+	if identity.DeviceID == 0 {
+		return queue.TicketFilter{}, nil
+	}
+	device, err := auth.GetDevice(identity.DeviceID)
+	if err != nil || device.ZoneID == 0 {
+		return queue.TicketFilter{}, err
+	}
+	zone, err := queue.GetZone(device.ZoneID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return queue.TicketFilter{}, nil
+	}
+	return zone.TicketFilter, err
+}
+
+// zoneFilter finds the zone of a connecting live client for the hub
+func zoneFilter(identity auth.Identity) (*handlers.ZoneFilter, error) {
+	filter, err := ticketFilterFor(identity)
+	if err != nil || (len(filter.CategoryIDs) == 0 && len(filter.Counters) == 0) {
+		return nil, err
+	}
+	return &handlers.ZoneFilter{Categories: filter.CategoryIDs, Counters: filter.Counters}, nil
+}
+
+// parseIDList reads a comma separated list of numbers such as "1,2"
+func parseIDList(value string) ([]int, error) {
+	var ids []int
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		id, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, errors.New("invalid number " + strconv.Quote(part))
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// zoneDisplays returns the paired displays assigned to a zone
+func zoneDisplays(zoneID int) []auth.Device {
+	devices, err := auth.ListDevices()
+	if err != nil {
+		return nil
+	}
+	var displays []auth.Device
+	for _, d := range devices {
+		if d.ZoneID == zoneID && d.Status == "active" {
+			displays = append(displays, d)
+		}
+	}
+	return displays
+}
+
+// reloadDisplays makes displays reconnect, as zones are applied when they
+// connect
+func reloadDisplays(displays []auth.Device) {
+	for _, d := range displays {
+		hub.Publish(handlers.EventDeviceCommand, DeviceCommand{Command: "reload", Name: d.Name}, handlers.DeviceTopic(d.ID))
+	}
+}
+
+func zoneError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, queue.ErrInvalidZone):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Zone not found", http.StatusNotFound)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func ListZonesHandler(w http.ResponseWriter, r *http.Request) {
+	zones, err := queue.ListZones()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(zones)
+}
+
+func CreateZoneHandler(w http.ResponseWriter, r *http.Request) {
+	var z queue.Zone
+	if err := json.NewDecoder(r.Body).Decode(&z); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	z, err := queue.CreateZone(z)
+	if err != nil {
+		zoneError(w, err)
+		return
+	}
+
+	audit.Record(currentIdentity(r), "zone_create", 0, z.Name)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(z)
+}
+
+func UpdateZoneHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid zone ID", http.StatusBadRequest)
+		return
+	}
+
+	var z queue.Zone
+	if err := json.NewDecoder(r.Body).Decode(&z); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	z.ID = id
+
+	z, err = queue.UpdateZone(z)
+	if err != nil {
+		zoneError(w, err)
+		return
+	}
+
+	audit.Record(currentIdentity(r), "zone_update", 0, z.Name)
+	reloadDisplays(zoneDisplays(id))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(z)
+}
+
+func DeleteZoneHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid zone ID", http.StatusBadRequest)
+		return
+	}
+
+	z, err := queue.GetZone(id)
+	if err != nil {
+		zoneError(w, err)
+		return
+	}
+	// Find the displays before they are unassigned
+	displays := zoneDisplays(id)
+
+	if err := queue.DeleteZone(id); err != nil {
+		zoneError(w, err)
+		return
+	}
+
+	audit.Record(currentIdentity(r), "zone_delete", 0, z.Name)
+	reloadDisplays(displays)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
+}
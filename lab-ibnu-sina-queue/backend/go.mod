@@ -6,6 +6,7 @@ require (
 	github.com/go-sql-driver/mysql v1.9.3
 	github.com/gorilla/mux v1.8.1
 	github.com/gorilla/websocket v1.5.3
+	golang.org/x/crypto v0.31.0
 )
 
 require filippo.io/edwards25519 v1.1.0 // indirect
@@ -0,0 +1,54 @@
+package audit
+
+import (
+	"lab-ibnu-sina-queue/internal/auth"
+	"lab-ibnu-sina-queue/internal/database"
+	"log"
+	"time"
+)
+
+// Entry is one recorded staff action
+type Entry struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	Username  string    `json:"username"`
+	Action    string    `json:"action"`
+	TicketID  int       `json:"ticket_id,omitempty"`
+	Detail    string    `json:"detail"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Record stores an action performed by the identity. Failures are only
+// logged so auditing never blocks the action itself.
+func Record(actor auth.Identity, action string, ticketID int, detail string) {
+	_, err := database.DB.Exec(`
+		INSERT INTO audit_logs (user_id, username, action, ticket_id, detail)
+		VALUES (?, ?, ?, ?, ?)
+	`, actor.UserID, actor.Username, action, ticketID, detail)
+	if err != nil {
+		log.Printf("Error writing audit log: %v", err)
+	}
+}
+
+// List returns the most recent audit entries, newest first
+func List(limit int) ([]Entry, error) {
+	rows, err := database.DB.Query(`
+		SELECT id, user_id, username, action, ticket_id, detail, created_at
+		FROM audit_logs
+		ORDER BY id DESC LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []Entry{}
+	for rows.Next() {
+		var e Entry
+		if err := rows.Scan(&e.ID, &e.UserID, &e.Username, &e.Action, &e.TicketID, &e.Detail, &e.CreatedAt); err != nil {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
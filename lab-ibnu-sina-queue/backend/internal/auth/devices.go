@@ -0,0 +1,292 @@
+package auth
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"lab-ibnu-sina-queue/internal/database"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidDeviceRole  = errors.New("devices must have the kiosk or display role")
+	ErrInvalidPairingCode = errors.New("invalid or expired pairing code")
+	ErrInvalidDeviceKey   = errors.New("invalid or revoked device key")
+	ErrInvalidLayout      = errors.New("invalid layout, expected standard, calls-only, mirrored or board")
+)
+
+const (
+	DeviceKeyPrefix = "dk_"
+
+	// pairingCodeTTL is how long an issued pairing code can be exchanged
+	pairingCodeTTL = 15 * time.Minute
+
+	// maxPairingAttempts is how many wrong codes may be tried while a code
+	// is open before it is cancelled and has to be reissued
+	maxPairingAttempts = 10
+
+	// touchInterval limits how often last-seen is written per device
+	touchInterval = time.Minute
+
+	// pairingAlphabet leaves out characters that are easy to misread on a TV
+	pairingAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+)
+
+// Device is an unattended kiosk or display enrolled by an admin
+type Device struct {
+	ID               int        `json:"id"`
+	Name             string     `json:"name"`
+	Role             Role       `json:"role"`
+	Status           string     `json:"status"`
+	PairingExpiresAt *time.Time `json:"pairing_expires_at,omitempty"`
+	LastSeenAt       *time.Time `json:"last_seen_at,omitempty"`
+	LastIP           string     `json:"last_ip"`
+	Location         string     `json:"location"`
+	Version          string     `json:"version"`
+	RegisteredAt     *time.Time `json:"registered_at,omitempty"`
+	Layout           string     `json:"layout"`
+	Muted            bool       `json:"muted"`
+	ZoneID           int        `json:"zone_id"`
+	CreatedAt        time.Time  `json:"created_at"`
+}
+
+// Display layouts a device can be switched to remotely
+var deviceLayouts = map[string]bool{
+	"standard":   true,
+	"calls-only": true,
+	"mirrored":   true,
+	"board":      true,
+}
+
+// Identity returns the request identity of the device
+func (d Device) Identity() Identity {
+	return Identity{
+		DeviceID:    d.ID,
+		Username:    "device/" + d.Name,
+		DisplayName: d.Name,
+		Role:        d.Role,
+	}
+}
+
+const deviceColumns = `id, name, role, status, pairing_expires_at, last_seen_at, COALESCE(last_ip, ''),
+	location, app_version, registered_at, layout, muted, COALESCE(zone_id, 0), created_at`
+
+func scanDevice(row interface{ Scan(...interface{}) error }) (Device, error) {
+	var d Device
+	var pairingExpiresAt, lastSeenAt, registeredAt sql.NullTime
+	err := row.Scan(&d.ID, &d.Name, &d.Role, &d.Status, &pairingExpiresAt, &lastSeenAt, &d.LastIP,
+		&d.Location, &d.Version, &registeredAt, &d.Layout, &d.Muted, &d.ZoneID, &d.CreatedAt)
+	if pairingExpiresAt.Valid {
+		d.PairingExpiresAt = &pairingExpiresAt.Time
+	}
+	if lastSeenAt.Valid {
+		d.LastSeenAt = &lastSeenAt.Time
+	}
+	if registeredAt.Valid {
+		d.RegisteredAt = &registeredAt.Time
+	}
+	return d, err
+}
+
+// IsDeviceKey reports whether a bearer token looks like a device API key
+func IsDeviceKey(token string) bool {
+	return strings.HasPrefix(token, DeviceKeyPrefix)
+}
+
+// newPairingCode returns a short code an admin can read out to a device
+func newPairingCode() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	for i := range b {
+		b[i] = pairingAlphabet[int(b[i])%len(pairingAlphabet)]
+	}
+	return string(b)
+}
+
+// CreateDevice enrolls a new device and returns its one-time pairing code
+func CreateDevice(name string, role Role) (Device, string, error) {
+	if role != RoleKiosk && role != RoleDisplay {
+		return Device{}, "", ErrInvalidDeviceRole
+	}
+
+	code := newPairingCode()
+	res, err := database.DB.Exec(`
+		INSERT INTO devices (name, role, status, pairing_code_hash, pairing_expires_at)
+		VALUES (?, ?, 'pending', ?, ?)
+	`, name, role, hashToken(code), time.Now().Add(pairingCodeTTL))
+	if err != nil {
+		return Device{}, "", err
+	}
+
+	id, _ := res.LastInsertId()
+	d, err := GetDevice(int(id))
+	return d, code, err
+}
+
+// ReissuePairingCode issues a fresh pairing code for a device. The current
+// key stops working so a lost or replaced device can be paired again.
+func ReissuePairingCode(id int) (Device, string, error) {
+	code := newPairingCode()
+	_, err := database.DB.Exec(`
+		UPDATE devices SET status = 'pending', api_key_hash = NULL,
+			pairing_code_hash = ?, pairing_expires_at = ?, pairing_attempts = 0
+		WHERE id = ?
+	`, hashToken(code), time.Now().Add(pairingCodeTTL), id)
+	if err != nil {
+		return Device{}, "", err
+	}
+
+	d, err := GetDevice(id)
+	return d, code, err
+}
+
+// PairDevice exchanges a pairing code for the device's long-lived API key
+func PairDevice(code string) (Device, string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+
+	var id int
+	err := database.DB.QueryRow(`
+		SELECT id FROM devices
+		WHERE pairing_code_hash = ? AND pairing_expires_at > ? AND status = 'pending'
+	`, hashToken(code), time.Now()).Scan(&id)
+	if err != nil {
+		failPairingAttempt()
+		return Device{}, "", ErrInvalidPairingCode
+	}
+
+	key := DeviceKeyPrefix + randomToken(32)
+	_, err = database.DB.Exec(`
+		UPDATE devices SET status = 'active', api_key_hash = ?,
+			pairing_code_hash = NULL, pairing_expires_at = NULL
+		WHERE id = ?
+	`, hashToken(key), id)
+	if err != nil {
+		return Device{}, "", err
+	}
+
+	d, err := GetDevice(id)
+	return d, key, err
+}
+
+// failPairingAttempt counts a wrong code against every open pairing code,
+// since a guess cannot be told apart from a mistyped code, and cancels the
+// codes that reached maxPairingAttempts
+func failPairingAttempt() {
+	if _, err := database.DB.Exec(`
+		UPDATE devices SET pairing_attempts = pairing_attempts + 1
+		WHERE status = 'pending' AND pairing_code_hash IS NOT NULL
+	`); err != nil {
+		log.Printf("Error counting pairing attempt: %v", err)
+		return
+	}
+	if _, err := database.DB.Exec(`
+		UPDATE devices SET pairing_code_hash = NULL, pairing_expires_at = NULL
+		WHERE status = 'pending' AND pairing_code_hash IS NOT NULL AND pairing_attempts >= ?
+	`, maxPairingAttempts); err != nil {
+		log.Printf("Error cancelling pairing codes: %v", err)
+	}
+}
+
+// GetDevice finds a device by ID
+func GetDevice(id int) (Device, error) {
+	return scanDevice(database.DB.QueryRow(`SELECT `+deviceColumns+` FROM devices WHERE id = ?`, id))
+}
+
+// GetDeviceByKey returns the active device owning an API key
+func GetDeviceByKey(key string) (Device, error) {
+	d, err := scanDevice(database.DB.QueryRow(`
+		SELECT `+deviceColumns+` FROM devices
+		WHERE api_key_hash = ? AND status = 'active'
+	`, hashToken(key)))
+	if err != nil {
+		return Device{}, ErrInvalidDeviceKey
+	}
+	return d, nil
+}
+
+// ListDevices returns all enrolled devices
+func ListDevices() ([]Device, error) {
+	rows, err := database.DB.Query(`SELECT ` + deviceColumns + ` FROM devices ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	devices := []Device{}
+	for rows.Next() {
+		d, err := scanDevice(rows)
+		if err != nil {
+			continue
+		}
+		devices = append(devices, d)
+	}
+	return devices, nil
+}
+
+// RevokeDevice permanently disables a device's API key
+func RevokeDevice(id int) error {
+	_, err := database.DB.Exec(`
+		UPDATE devices SET status = 'revoked', api_key_hash = NULL,
+			pairing_code_hash = NULL, pairing_expires_at = NULL
+		WHERE id = ?
+	`, id)
+	return err
+}
+
+// RegisterDevice stores the location and software version a device reports
+// when it connects
+func RegisterDevice(id int, location, version string) (Device, error) {
+	_, err := database.DB.Exec(`
+		UPDATE devices SET location = ?, app_version = ?, registered_at = ?
+		WHERE id = ?
+	`, location, version, time.Now(), id)
+	if err != nil {
+		return Device{}, err
+	}
+	return GetDevice(id)
+}
+
+// SetDeviceLayout stores the layout a display shows
+func SetDeviceLayout(id int, layout string) error {
+	if !deviceLayouts[layout] {
+		return ErrInvalidLayout
+	}
+	_, err := database.DB.Exec(`UPDATE devices SET layout = ? WHERE id = ?`, layout, id)
+	return err
+}
+
+// SetDeviceMuted stores whether a device plays audio
+func SetDeviceMuted(id int, muted bool) error {
+	_, err := database.DB.Exec(`UPDATE devices SET muted = ? WHERE id = ?`, muted, id)
+	return err
+}
+
+// SetDeviceZone assigns a display to a zone, 0 for none
+func SetDeviceZone(id int, zoneID int) error {
+	_, err := database.DB.Exec(`UPDATE devices SET zone_id = ? WHERE id = ?`, sql.NullInt64{Int64: int64(zoneID), Valid: zoneID != 0}, id)
+	return err
+}
+
+var (
+	touchMu   sync.Mutex
+	lastTouch = make(map[int]time.Time)
+)
+
+// TouchDevice records that a device was just seen. Writes are throttled
+// since displays poll and reconnect often.
+func TouchDevice(id int, ip string) {
+	touchMu.Lock()
+	if time.Since(lastTouch[id]) < touchInterval {
+		touchMu.Unlock()
+		return
+	}
+	lastTouch[id] = time.Now()
+	touchMu.Unlock()
+
+	database.DB.Exec(`UPDATE devices SET last_seen_at = ?, last_ip = ? WHERE id = ?`, time.Now(), ip, id)
+}
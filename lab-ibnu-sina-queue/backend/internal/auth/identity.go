@@ -0,0 +1,31 @@
+package auth
+
+import "context"
+
+// Identity is the authenticated caller of a request, either a staff
+// account or an enrolled device
+type Identity struct {
+	UserID      int    `json:"user_id,omitempty"`
+	DeviceID    int    `json:"device_id,omitempty"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name"`
+	Role        Role   `json:"role"`
+}
+
+// Can reports whether the identity's role grants the permission
+func (i Identity) Can(p Permission) bool {
+	return i.Role.Can(p)
+}
+
+type contextKey struct{}
+
+// WithIdentity returns a copy of ctx carrying the identity
+func WithIdentity(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the identity stored by WithIdentity
+func FromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(contextKey{}).(Identity)
+	return id, ok
+}
@@ -0,0 +1,68 @@
+package auth
+
+// Role is the job of a staff account or device
+type Role string
+
+const (
+	RoleAdmin      Role = "admin"
+	RoleSupervisor Role = "supervisor"
+	RoleCounter    Role = "counter"
+	RoleDisplay    Role = "display"
+	RoleKiosk      Role = "kiosk"
+)
+
+// Permission is a single action a route can require
+type Permission string
+
+const (
+	PermTicketCreate  Permission = "ticket.create"
+	PermQueueView     Permission = "queue.view"
+	PermQueueOperate  Permission = "queue.operate"
+	PermQueueReset    Permission = "queue.reset"
+	PermDisplayView   Permission = "display.view"
+	PermDisplayManage Permission = "display.manage"
+	PermUserManage    Permission = "user.manage"
+	PermDeviceManage  Permission = "device.manage"
+	PermAuditView     Permission = "audit.view"
+	PermLiveSubscribe Permission = "live.subscribe"
+	PermEmergency     Permission = "emergency.broadcast"
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleAdmin: {
+		PermTicketCreate, PermQueueView, PermQueueOperate, PermQueueReset,
+		PermDisplayView, PermDisplayManage, PermUserManage, PermDeviceManage, PermAuditView,
+		PermLiveSubscribe, PermEmergency,
+	},
+	RoleSupervisor: {
+		PermTicketCreate, PermQueueView, PermQueueOperate, PermQueueReset,
+		PermDisplayView, PermDisplayManage, PermDeviceManage, PermAuditView,
+		PermLiveSubscribe, PermEmergency,
+	},
+	RoleCounter: {
+		PermTicketCreate, PermQueueView, PermQueueOperate, PermDisplayView,
+		PermLiveSubscribe,
+	},
+	RoleDisplay: {
+		PermDisplayView, PermLiveSubscribe,
+	},
+	RoleKiosk: {
+		PermTicketCreate, PermLiveSubscribe,
+	},
+}
+
+// Valid reports whether r is one of the known roles
+func (r Role) Valid() bool {
+	_, ok := rolePermissions[r]
+	return ok
+}
+
+// Can reports whether the role grants the permission
+func (r Role) Can(p Permission) bool {
+	for _, granted := range rolePermissions[r] {
+		if granted == p {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"lab-ibnu-sina-queue/internal/database"
+	"os"
+	"strconv"
+	"time"
+)
+
+var ErrInvalidSession = errors.New("invalid or expired session")
+
+// SessionTTL is how long a login stays valid, configured by SESSION_TTL_HOURS
+func SessionTTL() time.Duration {
+	if hours, err := strconv.Atoi(os.Getenv("SESSION_TTL_HOURS")); err == nil && hours > 0 {
+		return time.Duration(hours) * time.Hour
+	}
+	return 12 * time.Hour
+}
+
+// hashToken is what gets stored, so a leaked table does not leak logins
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateSession starts a login session for the user and returns its token
+func CreateSession(userID int) (string, time.Time, error) {
+	token := randomToken(32)
+	expires := time.Now().Add(SessionTTL())
+
+	_, err := database.DB.Exec(`
+		INSERT INTO sessions (token_hash, user_id, expires_at) VALUES (?, ?, ?)
+	`, hashToken(token), userID, expires)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expires, nil
+}
+
+// GetSessionUser returns the active user owning a session token
+func GetSessionUser(token string) (User, error) {
+	u, err := scanUser(database.DB.QueryRow(`
+		SELECT u.id, u.username, u.display_name, u.role, u.active, u.created_at
+		FROM sessions s JOIN users u ON u.id = s.user_id
+		WHERE s.token_hash = ? AND s.expires_at > ? AND u.active = TRUE
+	`, hashToken(token), time.Now()))
+	if err != nil {
+		return User{}, ErrInvalidSession
+	}
+	return u, nil
+}
+
+// DeleteSession ends a login session
+func DeleteSession(token string) error {
+	_, err := database.DB.Exec(`DELETE FROM sessions WHERE token_hash = ?`, hashToken(token))
+	return err
+}
+
+// DeleteExpiredSessions removes sessions past their expiry
+func DeleteExpiredSessions() error {
+	_, err := database.DB.Exec(`DELETE FROM sessions WHERE expires_at <= ?`, time.Now())
+	return err
+}
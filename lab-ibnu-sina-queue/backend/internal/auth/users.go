@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"lab-ibnu-sina-queue/internal/database"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrInvalidRole        = errors.New("invalid role")
+	ErrUsernameTaken      = errors.New("username already exists")
+)
+
+type User struct {
+	ID          int       `json:"id"`
+	Username    string    `json:"username"`
+	DisplayName string    `json:"display_name"`
+	Role        Role      `json:"role"`
+	Active      bool      `json:"active"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Identity returns the request identity of the user
+func (u User) Identity() Identity {
+	return Identity{
+		UserID:      u.ID,
+		Username:    u.Username,
+		DisplayName: u.DisplayName,
+		Role:        u.Role,
+	}
+}
+
+const userColumns = `id, username, display_name, role, active, created_at`
+
+func scanUser(row interface{ Scan(...interface{}) error }) (User, error) {
+	var u User
+	err := row.Scan(&u.ID, &u.Username, &u.DisplayName, &u.Role, &u.Active, &u.CreatedAt)
+	return u, err
+}
+
+// CreateUser stores a new account with a bcrypt hashed password
+func CreateUser(username, displayName, password string, role Role) (User, error) {
+	username = strings.TrimSpace(username)
+	if !role.Valid() {
+		return User{}, ErrInvalidRole
+	}
+
+	var exists int
+	database.DB.QueryRow(`SELECT COUNT(*) FROM users WHERE username = ?`, username).Scan(&exists)
+	if exists > 0 {
+		return User{}, ErrUsernameTaken
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return User{}, err
+	}
+
+	res, err := database.DB.Exec(`
+		INSERT INTO users (username, display_name, password_hash, role, active)
+		VALUES (?, ?, ?, ?, TRUE)
+	`, username, displayName, string(hash), role)
+	if err != nil {
+		return User{}, err
+	}
+
+	id, _ := res.LastInsertId()
+	return GetUser(int(id))
+}
+
+// GetUser finds a user by ID
+func GetUser(id int) (User, error) {
+	return scanUser(database.DB.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, id))
+}
+
+// ListUsers returns all accounts ordered by username
+func ListUsers() ([]User, error) {
+	rows, err := database.DB.Query(`SELECT ` + userColumns + ` FROM users ORDER BY username`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			continue
+		}
+		users = append(users, u)
+	}
+	return users, nil
+}
+
+// UpdateUser changes the profile, role and active flag of a user.
+// An empty password keeps the current one.
+func UpdateUser(id int, displayName string, role Role, active bool, password string) (User, error) {
+	if !role.Valid() {
+		return User{}, ErrInvalidRole
+	}
+
+	_, err := database.DB.Exec(`
+		UPDATE users SET display_name = ?, role = ?, active = ? WHERE id = ?
+	`, displayName, role, active, id)
+	if err != nil {
+		return User{}, err
+	}
+
+	if password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return User{}, err
+		}
+		if _, err := database.DB.Exec(`UPDATE users SET password_hash = ? WHERE id = ?`, string(hash), id); err != nil {
+			return User{}, err
+		}
+	}
+
+	if !active {
+		// Deactivated accounts are logged out everywhere
+		database.DB.Exec(`DELETE FROM sessions WHERE user_id = ?`, id)
+	}
+
+	return GetUser(id)
+}
+
+// Authenticate checks a username and password against the stored hash
+func Authenticate(username, password string) (User, error) {
+	var hash string
+	row := database.DB.QueryRow(`
+		SELECT `+userColumns+`, password_hash FROM users WHERE username = ?
+	`, username)
+
+	var u User
+	err := row.Scan(&u.ID, &u.Username, &u.DisplayName, &u.Role, &u.Active, &u.CreatedAt, &hash)
+	if err == sql.ErrNoRows {
+		return User{}, ErrInvalidCredentials
+	}
+	if err != nil {
+		return User{}, err
+	}
+
+	if !u.Active || bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return User{}, ErrInvalidCredentials
+	}
+	return u, nil
+}
+
+// EnsureAdmin creates the first admin account when no users exist yet.
+// Credentials come from ADMIN_USERNAME / ADMIN_PASSWORD; without a
+// password a random one is generated and logged once.
+func EnsureAdmin() {
+	var count int
+	if err := database.DB.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&count); err != nil {
+		log.Printf("Could not count users: %v", err)
+		return
+	}
+	if count > 0 {
+		return
+	}
+
+	username := os.Getenv("ADMIN_USERNAME")
+	if username == "" {
+		username = "admin"
+	}
+
+	password := os.Getenv("ADMIN_PASSWORD")
+	generated := password == ""
+	if generated {
+		password = randomToken(8)
+	}
+
+	if _, err := CreateUser(username, "Administrator", password, RoleAdmin); err != nil {
+		log.Printf("Could not create initial admin: %v", err)
+		return
+	}
+
+	if generated {
+		log.Printf("Initial admin account created: %s / %s (change this password)", username, password)
+	} else {
+		log.Printf("Initial admin account created: %s", username)
+	}
+}
+
+// randomToken returns n random bytes encoded as hex
+func randomToken(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,188 @@
+package broker
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"lab-ibnu-sina-queue/internal/database"
+	"lab-ibnu-sina-queue/internal/handlers"
+)
+
+const (
+	// retention is how long shared events stay in the table
+	retention = time.Hour
+
+	// cleanupInterval is how often expired events are deleted
+	cleanupInterval = 5 * time.Minute
+
+	// pollBatch limits the events read per poll
+	pollBatch = 500
+
+	// gapTimeout is how long a skipped ID is looked for again. IDs are
+	// taken when an insert starts, so another instance's event can commit
+	// after a higher ID was already read; IDs of rolled back inserts never
+	// show up at all.
+	gapTimeout = 30 * time.Second
+
+	// maxGaps limits the skipped IDs tracked at once
+	maxGaps = 1000
+)
+
+// MySQL shares events between server instances through the live_events
+// table. Each instance writes the events it publishes and polls for the
+// ones written by others, so no infrastructure beyond the database is
+// needed. Local events reach local clients right away; other instances see
+// them after at most one poll interval.
+type MySQL struct {
+	hub      *handlers.Hub
+	instance string
+	interval time.Duration
+	outbox   chan handlers.BrokerEvent
+	lastID   int64
+
+	// Skipped IDs below lastID and when to stop looking for them
+	gaps map[int64]time.Time
+}
+
+// NewMySQL creates a broker for hub polling every interval
+func NewMySQL(hub *handlers.Hub, interval time.Duration) *MySQL {
+	return &MySQL{
+		hub:      hub,
+		instance: newInstanceID(),
+		interval: interval,
+		outbox:   make(chan handlers.BrokerEvent, 1024),
+		gaps:     make(map[int64]time.Time),
+	}
+}
+
+func newInstanceID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Publish delivers an event locally and queues it for the other instances
+func (b *MySQL) Publish(event handlers.BrokerEvent) {
+	b.hub.Dispatch(event)
+
+	select {
+	case b.outbox <- event:
+	default:
+		log.Printf("Broker outbox full, %s event not shared with other instances", event.Type)
+	}
+}
+
+// Run writes local events and polls for remote ones until the process exits
+func (b *MySQL) Run() {
+	// Only events published from now on are of interest
+	if err := database.DB.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM live_events`).Scan(&b.lastID); err != nil {
+		log.Printf("Error reading live events: %v", err)
+	}
+
+	go b.write()
+
+	poll := time.NewTicker(b.interval)
+	defer poll.Stop()
+	cleanup := time.NewTicker(cleanupInterval)
+	defer cleanup.Stop()
+
+	for {
+		select {
+		case <-poll.C:
+			if err := b.poll(); err != nil {
+				log.Printf("Error polling live events: %v", err)
+			}
+		case <-cleanup.C:
+			if _, err := database.DB.Exec(`DELETE FROM live_events WHERE created_at < ?`, time.Now().Add(-retention)); err != nil {
+				log.Printf("Error cleaning up live events: %v", err)
+			}
+		}
+	}
+}
+
+// write stores queued local events in publish order
+func (b *MySQL) write() {
+	for event := range b.outbox {
+		data, err := json.Marshal(event.Data)
+		if err != nil {
+			log.Printf("Error encoding %s event: %v", event.Type, err)
+			continue
+		}
+
+		_, err = database.DB.Exec(`
+			INSERT INTO live_events (instance, type, topics, data) VALUES (?, ?, ?, ?)
+		`, b.instance, event.Type, strings.Join(event.Topics, ","), data)
+		if err != nil {
+			log.Printf("Error sharing %s event: %v", event.Type, err)
+		}
+	}
+}
+
+// poll dispatches events written by other instances since the last poll,
+// and those of skipped IDs that have committed since
+func (b *MySQL) poll() error {
+	where := `id > ?`
+	args := []interface{}{b.lastID}
+	if len(b.gaps) > 0 {
+		where += ` OR id IN (` + strings.TrimSuffix(strings.Repeat("?, ", len(b.gaps)), ", ") + `)`
+		for id := range b.gaps {
+			args = append(args, id)
+		}
+	}
+	args = append(args, pollBatch)
+
+	rows, err := database.DB.Query(`
+		SELECT id, instance, type, topics, data FROM live_events
+		WHERE `+where+` ORDER BY id LIMIT ?
+	`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	defer b.expireGaps(now)
+
+	for rows.Next() {
+		var id int64
+		var instance, eventType, topics string
+		var data []byte
+		if err := rows.Scan(&id, &instance, &eventType, &topics, &data); err != nil {
+			return err
+		}
+		if id > b.lastID {
+			for missing := b.lastID + 1; missing < id && len(b.gaps) < maxGaps; missing++ {
+				b.gaps[missing] = now.Add(gapTimeout)
+			}
+			b.lastID = id
+		} else {
+			delete(b.gaps, id)
+		}
+
+		if instance == b.instance {
+			continue
+		}
+
+		event := handlers.BrokerEvent{Type: handlers.EventType(eventType), Data: json.RawMessage(data)}
+		if topics != "" {
+			event.Topics = strings.Split(topics, ",")
+		}
+		b.hub.Dispatch(event)
+	}
+	return rows.Err()
+}
+
+// expireGaps stops looking for skipped IDs that did not show up in time
+func (b *MySQL) expireGaps(now time.Time) {
+	for id, until := range b.gaps {
+		if now.After(until) {
+			delete(b.gaps, id)
+		}
+	}
+}
@@ -76,12 +76,24 @@ func migrate() {
 			category_id INT,
 			ticket_number INT,
 			formatted_code VARCHAR(10),
-			status ENUM('waiting', 'calling', 'serving', 'skipped', 'finished') DEFAULT 'waiting',
+			status ENUM('waiting', 'calling', 'serving', 'skipped', 'finished', 'held', 'voided') DEFAULT 'waiting',
 			counter_number INT DEFAULT 0,
+			hold_reason VARCHAR(255) NULL,
+			hold_until TIMESTAMP NULL,
+			first_called_at TIMESTAMP NULL,
+			called_at TIMESTAMP NULL,
+			serving_started_at TIMESTAMP NULL,
+			finished_at TIMESTAMP NULL,
+			call_count INT NOT NULL DEFAULT 0,
+			recall_count INT NOT NULL DEFAULT 0,
+			source_device_id INT NOT NULL DEFAULT 0,
+			source_ip VARCHAR(45) NOT NULL DEFAULT '',
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
 			FOREIGN KEY (category_id) REFERENCES categories(id)
 		);`,
+		// Upgrade status values of existing databases
+		`ALTER TABLE queues MODIFY status ENUM('waiting', 'calling', 'serving', 'skipped', 'finished', 'held', 'voided') DEFAULT 'waiting';`,
 		// Seed Categories if empty
 		`INSERT INTO categories (id, name, prefix, color_code) 
 		 SELECT 1, 'Periksa Lab', 'A', '#2563eb' WHERE NOT EXISTS (SELECT 1 FROM categories WHERE id = 1);`,
@@ -102,6 +114,140 @@ func migrate() {
 		`INSERT INTO display_settings (id, video_url, title, subtitle) 
 		 SELECT 1, '', 'Pentingnya Mencuci Tangan', 'Tips Kesehatan Harian' 
 		 WHERE NOT EXISTS (SELECT 1 FROM display_settings WHERE id = 1);`,
+
+		// Emergency override of every display and kiosk, a single row
+		`CREATE TABLE IF NOT EXISTS emergency_state (
+			id INT PRIMARY KEY DEFAULT 1,
+			active BOOLEAN NOT NULL DEFAULT FALSE,
+			message TEXT NOT NULL,
+			started_by VARCHAR(50) NOT NULL DEFAULT '',
+			started_at TIMESTAMP NULL
+		);`,
+		`INSERT INTO emergency_state (id, active, message)
+		 SELECT 1, FALSE, ''
+		 WHERE NOT EXISTS (SELECT 1 FROM emergency_state WHERE id = 1);`,
+
+		// When the next spoken call may start, shared by every server
+		// instance so calls to different counters never overlap
+		`CREATE TABLE IF NOT EXISTS call_schedule (
+			id INT PRIMARY KEY DEFAULT 1,
+			next_at DATETIME(3) NULL
+		);`,
+		`INSERT INTO call_schedule (id, next_at)
+		 SELECT 1, NULL
+		 WHERE NOT EXISTS (SELECT 1 FROM call_schedule WHERE id = 1);`,
+
+		// Content rotating on the displays' media area
+		`CREATE TABLE IF NOT EXISTS playlist_items (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			kind ENUM('video', 'image', 'text') NOT NULL,
+			title VARCHAR(255) NOT NULL DEFAULT '',
+			body TEXT NOT NULL,
+			media_url TEXT NOT NULL,
+			duration_seconds INT NOT NULL DEFAULT 0,
+			position INT NOT NULL DEFAULT 0,
+			starts_on DATE NULL,
+			ends_on DATE NULL,
+			daily_start TIME NULL,
+			daily_end TIME NULL,
+			weekdays VARCHAR(20) NOT NULL DEFAULT '',
+			enabled BOOLEAN NOT NULL DEFAULT TRUE,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+		);`,
+
+		// Running-text notices for the displays
+		`CREATE TABLE IF NOT EXISTS announcements (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			text VARCHAR(500) NOT NULL,
+			priority ENUM('normal', 'high', 'urgent') NOT NULL DEFAULT 'normal',
+			starts_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			ends_at TIMESTAMP NULL,
+			device_ids VARCHAR(255) NOT NULL DEFAULT '',
+			started BOOLEAN NOT NULL DEFAULT FALSE,
+			created_by VARCHAR(50) NOT NULL DEFAULT '',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);`,
+
+		// Uploaded videos and images, stored under MEDIA_PATH
+		`CREATE TABLE IF NOT EXISTS media_files (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			stored_name VARCHAR(80) NOT NULL UNIQUE,
+			content_type VARCHAR(50) NOT NULL,
+			size_bytes BIGINT NOT NULL,
+			uploaded_by VARCHAR(50) NOT NULL DEFAULT '',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);`,
+
+		// Staff accounts and login sessions
+		`CREATE TABLE IF NOT EXISTS users (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			username VARCHAR(50) NOT NULL UNIQUE,
+			display_name VARCHAR(100) NOT NULL DEFAULT '',
+			password_hash VARCHAR(100) NOT NULL,
+			role ENUM('admin', 'supervisor', 'counter', 'display', 'kiosk') NOT NULL,
+			active BOOLEAN NOT NULL DEFAULT TRUE,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);`,
+		`CREATE TABLE IF NOT EXISTS sessions (
+			token_hash CHAR(64) PRIMARY KEY,
+			user_id INT NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		);`,
+
+		// Display profiles limiting a display to some categories and counters
+		`CREATE TABLE IF NOT EXISTS display_zones (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			name VARCHAR(100) NOT NULL,
+			category_ids VARCHAR(255) NOT NULL DEFAULT '',
+			counters VARCHAR(255) NOT NULL DEFAULT '',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);`,
+
+		// Enrolled kiosks and displays
+		`CREATE TABLE IF NOT EXISTS devices (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			name VARCHAR(100) NOT NULL,
+			role ENUM('kiosk', 'display') NOT NULL,
+			status ENUM('pending', 'active', 'revoked') NOT NULL DEFAULT 'pending',
+			api_key_hash CHAR(64) NULL UNIQUE,
+			pairing_code_hash CHAR(64) NULL,
+			pairing_expires_at TIMESTAMP NULL,
+			last_seen_at TIMESTAMP NULL,
+			last_ip VARCHAR(45) NULL,
+			location VARCHAR(100) NOT NULL DEFAULT '',
+			app_version VARCHAR(50) NOT NULL DEFAULT '',
+			registered_at TIMESTAMP NULL,
+			layout VARCHAR(20) NOT NULL DEFAULT 'standard',
+			muted BOOLEAN NOT NULL DEFAULT FALSE,
+			zone_id INT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);`,
+
+		// Audit trail of staff actions
+		`CREATE TABLE IF NOT EXISTS audit_logs (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			user_id INT NOT NULL DEFAULT 0,
+			username VARCHAR(50) NOT NULL DEFAULT '',
+			action VARCHAR(50) NOT NULL,
+			ticket_id INT NOT NULL DEFAULT 0,
+			detail TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);`,
+
+		// Live events shared between server instances (LIVE_BROKER=mysql)
+		`CREATE TABLE IF NOT EXISTS live_events (
+			id BIGINT AUTO_INCREMENT PRIMARY KEY,
+			instance VARCHAR(32) NOT NULL,
+			type VARCHAR(50) NOT NULL,
+			topics VARCHAR(255) NOT NULL DEFAULT '',
+			data MEDIUMTEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			INDEX idx_live_events_created (created_at)
+		);`,
 	}
 
 	for _, q := range queries {
@@ -110,4 +256,52 @@ func migrate() {
 			log.Printf("Migration error: %v on query: %s", err, q)
 		}
 	}
+
+	// Columns added after the initial schema, created on existing databases
+	columns := []struct {
+		table, name, definition string
+	}{
+		{"queues", "hold_reason", "VARCHAR(255) NULL"},
+		{"queues", "hold_until", "TIMESTAMP NULL"},
+		{"queues", "first_called_at", "TIMESTAMP NULL"},
+		{"queues", "called_at", "TIMESTAMP NULL"},
+		{"queues", "serving_started_at", "TIMESTAMP NULL"},
+		{"queues", "finished_at", "TIMESTAMP NULL"},
+		{"queues", "call_count", "INT NOT NULL DEFAULT 0"},
+		{"queues", "recall_count", "INT NOT NULL DEFAULT 0"},
+		{"queues", "source_device_id", "INT NOT NULL DEFAULT 0"},
+		{"queues", "source_ip", "VARCHAR(45) NOT NULL DEFAULT ''"},
+		{"devices", "location", "VARCHAR(100) NOT NULL DEFAULT ''"},
+		{"devices", "app_version", "VARCHAR(50) NOT NULL DEFAULT ''"},
+		{"devices", "registered_at", "TIMESTAMP NULL"},
+		{"devices", "layout", "VARCHAR(20) NOT NULL DEFAULT 'standard'"},
+		{"devices", "muted", "BOOLEAN NOT NULL DEFAULT FALSE"},
+		{"devices", "zone_id", "INT NULL"},
+		{"devices", "pairing_attempts", "INT NOT NULL DEFAULT 0"},
+	}
+
+	for _, c := range columns {
+		addColumn(c.table, c.name, c.definition)
+	}
+}
+
+// addColumn adds a column to a table unless it already exists
+func addColumn(table, column, definition string) {
+	var count int
+	err := DB.QueryRow(`
+		SELECT COUNT(*) FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?
+	`, table, column).Scan(&count)
+	if err != nil {
+		log.Printf("Migration error: %v checking column %s.%s", err, table, column)
+		return
+	}
+	if count > 0 {
+		return
+	}
+
+	q := "ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition
+	if _, err := DB.Exec(q); err != nil {
+		log.Printf("Migration error: %v on query: %s", err, q)
+	}
 }
@@ -0,0 +1,29 @@
+package handlers
+
+// Broker carries published events to the hubs of every server instance, so
+// displays connected to one replica see calls made on another
+type Broker interface {
+	// Publish delivers an event to the local hub and to other instances
+	Publish(event BrokerEvent)
+}
+
+// BrokerEvent is an event on its way to the hubs
+type BrokerEvent struct {
+	Type   EventType
+	Data   interface{}
+	Topics []string
+}
+
+// LocalBroker only delivers to its own hub, for a single server instance
+type LocalBroker struct {
+	hub *Hub
+}
+
+func (b LocalBroker) Publish(event BrokerEvent) {
+	b.hub.Dispatch(event)
+}
+
+// SetBroker replaces the default LocalBroker. Call it before publishing.
+func (h *Hub) SetBroker(b Broker) {
+	h.broker = b
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"sort"
+	"time"
+
+	"lab-ibnu-sina-queue/internal/auth"
+)
+
+// ClientInfo describes a live connection for admins
+type ClientInfo struct {
+	UserID      int            `json:"user_id,omitempty"`
+	DeviceID    int            `json:"device_id,omitempty"`
+	Username    string         `json:"username"`
+	Role        auth.Role      `json:"role"`
+	Transport   string         `json:"transport"`
+	IP          string         `json:"ip"`
+	UserAgent   string         `json:"user_agent"`
+	Location    string         `json:"location,omitempty"`
+	Version     string         `json:"version,omitempty"`
+	Topics      []string       `json:"topics"`
+	Overflow    OverflowPolicy `json:"overflow"`
+	ConnectedAt time.Time      `json:"connected_at"`
+	LastPongAt  *time.Time     `json:"last_pong_at,omitempty"`
+	Sent        uint64         `json:"sent"`
+	Dropped     uint64         `json:"dropped"`
+	Queued      int            `json:"queued"`
+}
+
+// info snapshots the client's details. Called from Hub.Run, which owns the
+// topics.
+func (c *Client) info() ClientInfo {
+	topics := make([]string, 0, len(c.topics))
+	for topic := range c.topics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	info := ClientInfo{
+		UserID:      c.identity.UserID,
+		DeviceID:    c.identity.DeviceID,
+		Username:    c.identity.Username,
+		Role:        c.identity.Role,
+		Transport:   c.transport,
+		IP:          c.ip,
+		UserAgent:   c.userAgent,
+		Topics:      topics,
+		Overflow:    c.overflow,
+		ConnectedAt: c.connectedAt,
+		Sent:        c.sent.Load(),
+		Dropped:     c.dropped.Load(),
+		Queued:      len(c.send),
+	}
+	if reg := c.registration.Load(); reg != nil {
+		info.Location = reg.Location
+		info.Version = reg.Version
+	}
+	if pong := c.lastPong.Load(); pong > 0 {
+		t := time.Unix(0, pong)
+		info.LastPongAt = &t
+	}
+	return info
+}
+
+// Clients lists the connected clients, longest connected first
+func (h *Hub) Clients() []ClientInfo {
+	reply := make(chan []ClientInfo, 1)
+	h.inspect <- reply
+	infos := <-reply
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ConnectedAt.Before(infos[j].ConnectedAt)
+	})
+	return infos
+}
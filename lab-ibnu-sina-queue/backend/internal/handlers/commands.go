@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"lab-ibnu-sina-queue/internal/auth"
+)
+
+// Command is a request a client sends over the socket, e.g.
+//
+//	{"type": "COMMAND", "id": "c1", "command": "call-next", "payload": {"counter": 2}}
+//
+// It is answered with an ACK or ERROR reply carrying the same ID.
+type Command struct {
+	ID      string
+	Name    string
+	Payload json.RawMessage
+}
+
+// CommandError is a failed command with an HTTP-like status code
+type CommandError struct {
+	Code    int
+	Message string
+}
+
+func (e *CommandError) Error() string {
+	return e.Message
+}
+
+// CommandReply is the data of ACK and ERROR replies
+type CommandReply struct {
+	ID      string      `json:"id"`
+	Command string      `json:"command"`
+	Result  interface{} `json:"result,omitempty"`
+	Code    int         `json:"code,omitempty"`
+	Message string      `json:"message,omitempty"`
+}
+
+// CommandFunc executes a command on behalf of identity
+type CommandFunc func(identity auth.Identity, cmd Command) (interface{}, error)
+
+// SetCommandHandler sets the function executing client commands. Call it
+// before serving connections.
+func (h *Hub) SetCommandHandler(fn CommandFunc) {
+	h.commands = fn
+}
+
+// clientReply is a reply for a single client, sent through Hub.Run since it
+// owns the client's send channel
+type clientReply struct {
+	client    *Client
+	eventType EventType
+	data      interface{}
+}
+
+// runCommand executes a command from the client and queues the reply.
+// Commands of one client run in order, in its read loop.
+func (c *Client) runCommand(msg clientMessage) {
+	cmd := Command{ID: msg.ID, Name: msg.Command, Payload: msg.Payload}
+
+	var result interface{}
+	err := &CommandError{Code: http.StatusNotImplemented, Message: "commands are not supported"}
+	if c.hub.commands != nil {
+		var cmdErr error
+		result, cmdErr = c.hub.commands(c.identity, cmd)
+		if cmdErr == nil {
+			err = nil
+		} else if !errors.As(cmdErr, &err) {
+			err = &CommandError{Code: http.StatusInternalServerError, Message: cmdErr.Error()}
+		}
+	}
+
+	reply := clientReply{client: c, eventType: EventAck, data: CommandReply{ID: cmd.ID, Command: cmd.Name, Result: result}}
+	if err != nil {
+		reply.eventType = EventError
+		reply.data = CommandReply{ID: cmd.ID, Command: cmd.Name, Code: err.Code, Message: err.Message}
+	}
+	c.hub.replies <- reply
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"lab-ibnu-sina-queue/internal/auth"
+)
+
+// Registration is what a paired display or kiosk reports about itself
+// after connecting, e.g.
+//
+//	{"type": "REGISTER", "payload": {"location": "Lobby lantai 1", "version": "2.3.0"}}
+//
+// It is answered with a REGISTERED reply carrying the device's settings.
+type Registration struct {
+	Location string `json:"location"`
+	Version  string `json:"version"`
+}
+
+// RegisterFunc records a registration and returns the settings the device
+// should apply
+type RegisterFunc func(identity auth.Identity, reg Registration, ip string) (interface{}, error)
+
+// HeartbeatFunc records that a device connection is still alive
+type HeartbeatFunc func(identity auth.Identity, ip string)
+
+// SetRegisterHandler sets the function recording device registrations.
+// Call it before serving connections.
+func (h *Hub) SetRegisterHandler(fn RegisterFunc) {
+	h.registerDevice = fn
+}
+
+// SetHeartbeatHandler sets the function called for every ping a device
+// answers. Call it before serving connections.
+func (h *Hub) SetHeartbeatHandler(fn HeartbeatFunc) {
+	h.heartbeat = fn
+}
+
+// runRegister handles a REGISTER message. Only paired devices register;
+// staff and display accounts have no device ID.
+func (c *Client) runRegister(msg clientMessage) {
+	if c.identity.DeviceID == 0 {
+		c.hub.replies <- clientReply{client: c, eventType: EventError, data: map[string]string{"message": "only paired devices can register"}}
+		return
+	}
+
+	var reg Registration
+	if len(msg.Payload) > 0 {
+		if err := json.Unmarshal(msg.Payload, &reg); err != nil {
+			c.hub.replies <- clientReply{client: c, eventType: EventError, data: map[string]string{"message": err.Error()}}
+			return
+		}
+	}
+
+	var settings interface{}
+	if c.hub.registerDevice != nil {
+		var err error
+		settings, err = c.hub.registerDevice(c.identity, reg, c.ip)
+		if err != nil {
+			c.hub.replies <- clientReply{client: c, eventType: EventError, data: map[string]string{"message": err.Error()}}
+			return
+		}
+	}
+
+	c.registration.Store(&reg)
+	c.hub.replies <- clientReply{client: c, eventType: EventRegistered, data: settings}
+}
+
+// alive records a heartbeat: a pong on WebSockets, a written ping on SSE
+func (c *Client) alive() {
+	c.lastPong.Store(time.Now().UnixNano())
+	if c.identity.DeviceID != 0 && c.hub.heartbeat != nil {
+		c.hub.heartbeat(c.identity, c.ip)
+	}
+}
+
+// RevokeDevice cuts off a device whose key was revoked or whose pairing
+// code was reissued. It is published like any event, so the device's
+// connections to other server instances are closed too.
+func (h *Hub) RevokeDevice(deviceID int) {
+	h.Publish(EventDeviceRevoked, map[string]int{"device_id": deviceID}, DeviceTopic(deviceID))
+}
+
+// disconnectDevices closes the connections of the devices a DEVICE_REVOKED
+// event is addressed to, after it was queued for them. Called from Hub.Run.
+func (h *Hub) disconnectDevices(topics []string) {
+	for _, topic := range topics {
+		value, ok := strings.CutPrefix(topic, "device:")
+		if !ok {
+			continue
+		}
+		id, err := strconv.Atoi(value)
+		if err != nil || id == 0 {
+			continue
+		}
+		for client := range h.clients {
+			if client.identity.DeviceID == id {
+				log.Printf("Closing connection of revoked device %d", id)
+				h.stats.disconnects.Add(1)
+				close(client.send)
+				delete(h.clients, client)
+			}
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"encoding/json"
+	"time"
+
+	"lab-ibnu-sina-queue/internal/auth"
+)
+
+// EventType names an event pushed to live clients
+type EventType string
+
+const (
+	EventNewTicket       EventType = "NEW_TICKET"
+	EventCallTicket      EventType = "CALL_TICKET"
+	EventRecallTicket    EventType = "RECALL_TICKET"
+	EventServeTicket     EventType = "SERVE_TICKET"
+	EventHoldTicket      EventType = "HOLD_TICKET"
+	EventReleaseTicket   EventType = "RELEASE_TICKET"
+	EventTicketsVoided   EventType = "TICKETS_VOIDED"
+	EventResetQueue      EventType = "RESET_QUEUE"
+	EventUpdateVideo     EventType = "UPDATE_VIDEO"
+	EventUpdatePlaylist  EventType = "UPDATE_PLAYLIST"
+	EventSuspiciousBurst EventType = "SUSPICIOUS_BURST"
+
+	// EventBoardUpdate is the new state of one counter on the "now
+	// serving" board, published to its CounterTopic
+	EventBoardUpdate EventType = "BOARD_UPDATE"
+
+	// EventAnnounce tells displays when to speak a call or recall. The
+	// server spaces them so calls to different counters never overlap.
+	EventAnnounce EventType = "ANNOUNCE"
+
+	// Running-text notices; targeted ones are published to DeviceTopics
+	EventAnnouncement        EventType = "ANNOUNCEMENT"
+	EventAnnouncementRemoved EventType = "ANNOUNCEMENT_REMOVED"
+
+	// EventEmergency starts, changes or ends the full-screen emergency
+	// override of every display and kiosk
+	EventEmergency EventType = "EMERGENCY"
+
+	// EventDeviceCommand is a remote command for one display or kiosk,
+	// published to its DeviceTopic
+	EventDeviceCommand EventType = "DEVICE_COMMAND"
+
+	// EventDeviceRevoked tells a device its key no longer works; the hub
+	// then closes its connections. See Hub.RevokeDevice.
+	EventDeviceRevoked EventType = "DEVICE_REVOKED"
+
+	// EventSnapshot carries the full current state, sent on connect unless
+	// the client can be caught up from the replay buffer
+	EventSnapshot EventType = "SNAPSHOT"
+
+	// Replies to a single client, not sequenced
+	EventSubscribed EventType = "SUBSCRIBED"
+	EventAck        EventType = "ACK"
+	EventError      EventType = "ERROR"
+	EventRegistered EventType = "REGISTERED"
+)
+
+// Event is the JSON envelope sent to clients. Seq increases by one for every
+// published event; Epoch changes when the server restarts, which resets Seq.
+// Replies to a single client carry neither.
+type Event struct {
+	Seq   uint64      `json:"seq,omitempty"`
+	Epoch string      `json:"epoch,omitempty"`
+	Type  EventType   `json:"type"`
+	Time  time.Time   `json:"time"`
+	Data  interface{} `json:"data,omitempty"`
+}
+
+// outbound is an encoded event, the permission needed to receive it and
+// the topics it concerns
+type outbound struct {
+	seq    uint64
+	data   []byte
+	perm   auth.Permission
+	topics []string
+}
+
+// clientMessage is a request sent by a client over the socket
+type clientMessage struct {
+	Type    string          `json:"type"`
+	Topics  []string        `json:"topics"`
+	ID      string          `json:"id"`
+	Command string          `json:"command"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// eventPermissions maps event types to the permission a client needs to
+// receive them. Events not listed here are staff only.
+var eventPermissions = map[EventType]auth.Permission{
+	EventNewTicket:           auth.PermDisplayView,
+	EventTicketsVoided:       auth.PermDisplayView,
+	EventCallTicket:          auth.PermDisplayView,
+	EventRecallTicket:        auth.PermDisplayView,
+	EventAnnounce:            auth.PermDisplayView,
+	EventResetQueue:          auth.PermDisplayView,
+	EventUpdateVideo:         auth.PermDisplayView,
+	EventUpdatePlaylist:      auth.PermDisplayView,
+	EventAnnouncement:        auth.PermDisplayView,
+	EventAnnouncementRemoved: auth.PermDisplayView,
+	EventBoardUpdate:         auth.PermDisplayView,
+	EventSnapshot:            auth.PermDisplayView,
+
+	// Devices only ever subscribe to their own device topic
+	EventDeviceCommand: auth.PermLiveSubscribe,
+	EventDeviceRevoked: auth.PermLiveSubscribe,
+
+	// Kiosks have no display permission but must show the override too
+	EventEmergency: auth.PermLiveSubscribe,
+}
+
+func permissionFor(eventType EventType) auth.Permission {
+	if perm, ok := eventPermissions[eventType]; ok {
+		return perm
+	}
+	return auth.PermQueueView
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+)
+
+// OverflowPolicy decides what happens when a client's send buffer is full,
+// i.e. the client reads slower than events are published
+type OverflowPolicy string
+
+const (
+	// OverflowDropOldest discards the oldest queued message to make room
+	OverflowDropOldest OverflowPolicy = "drop-oldest"
+
+	// OverflowSnapshot discards everything queued and sends a fresh
+	// SNAPSHOT followed by the events published meanwhile
+	OverflowSnapshot OverflowPolicy = "snapshot"
+
+	// OverflowDisconnect closes the connection; the client reconnects and
+	// resumes from its last sequence
+	OverflowDisconnect OverflowPolicy = "disconnect"
+)
+
+// ParseOverflowPolicy validates a policy name; empty means the hub default
+func ParseOverflowPolicy(name string) (OverflowPolicy, error) {
+	switch p := OverflowPolicy(name); p {
+	case "", OverflowDropOldest, OverflowSnapshot, OverflowDisconnect:
+		return p, nil
+	}
+	return "", fmt.Errorf("invalid overflow policy %q, expected drop-oldest, snapshot or disconnect", name)
+}
+
+// HubStats counts events and how slow clients were handled
+type HubStats struct {
+	Clients     int    `json:"clients"`
+	Published   uint64 `json:"published"`
+	Pending     int    `json:"pending"`
+	Dropped     uint64 `json:"dropped"`
+	Resyncs     uint64 `json:"resyncs"`
+	Disconnects uint64 `json:"disconnects"`
+
+	// Overflowed counts events discarded before delivery because Run fell
+	// more than maxPending events behind
+	Overflowed uint64 `json:"overflowed"`
+}
+
+// resync is a snapshot built for a client whose buffer overflowed
+type resync struct {
+	client  *Client
+	initial *outbound
+	seq     uint64
+}
+
+// overflow applies the client's policy when its buffer is full. Called from
+// Hub.Run.
+func (h *Hub) overflow(client *Client, message outbound) {
+	switch client.overflow {
+	case OverflowDropOldest:
+		select {
+		case <-client.send:
+		default:
+		}
+		client.dropped.Add(1)
+		h.stats.dropped.Add(1)
+		select {
+		case client.send <- message:
+		default:
+		}
+
+	case OverflowSnapshot:
+		client.dropped.Add(uint64(len(client.send)) + 1)
+		h.stats.dropped.Add(uint64(len(client.send)) + 1)
+		h.resync(client)
+
+	default:
+		client.dropped.Add(1)
+		h.stats.dropped.Add(1)
+		h.disconnect(client)
+	}
+}
+
+// resyncAll brings every client back in step after events were discarded
+// before delivery. Clients that accept losing events are left alone.
+func (h *Hub) resyncAll() {
+	for client := range h.clients {
+		switch client.overflow {
+		case OverflowDropOldest:
+		case OverflowSnapshot:
+			if !client.resyncing {
+				h.resync(client)
+			}
+		default:
+			h.disconnect(client)
+		}
+	}
+}
+
+// resync discards what is queued for a client and sends it a fresh
+// snapshot followed by the events published meanwhile
+func (h *Hub) resync(client *Client) {
+	for len(client.send) > 0 {
+		<-client.send
+	}
+
+	// Events published while the snapshot is built are replayed after
+	// it, so the client skips them until then
+	client.resyncing = true
+	h.stats.resyncs.Add(1)
+	seq := h.lastSeq.Load()
+	go func() {
+		initial, err := h.snapshotEvent(client.identity, seq)
+		if err != nil {
+			log.Printf("Error building snapshot: %v", err)
+		}
+		h.resynced <- resync{client: client, initial: initial, seq: seq}
+	}()
+}
+
+// disconnect closes a client's connection; it reconnects and resumes from
+// its last sequence
+func (h *Hub) disconnect(client *Client) {
+	h.stats.disconnects.Add(1)
+	close(client.send)
+	delete(h.clients, client)
+}
+
+// finishResync sends a resynced client its snapshot and the events published
+// while it was built
+func (h *Hub) finishResync(rs resync) {
+	rs.client.resyncing = false
+	if rs.initial == nil {
+		// Without a snapshot the client has to reconnect and resume
+		h.stats.disconnects.Add(1)
+		close(rs.client.send)
+		delete(h.clients, rs.client)
+		return
+	}
+
+	rs.client.initial = rs.initial
+	rs.client.resumeFrom = rs.seq
+	h.catchUp(rs.client)
+}
@@ -0,0 +1,62 @@
+package handlers
+
+// replayBufferSize is how many recent events are kept for reconnecting
+// clients. A display offline for longer than that gets a snapshot instead.
+const replayBufferSize = 512
+
+// replayBuffer is a ring of the most recent events in sequence order. It is
+// only touched by Hub.Run.
+type replayBuffer struct {
+	events []outbound
+	next   int
+	full   bool
+}
+
+func newReplayBuffer(size int) *replayBuffer {
+	return &replayBuffer{events: make([]outbound, size)}
+}
+
+func (b *replayBuffer) add(ev outbound) {
+	b.events[b.next] = ev
+	b.next = (b.next + 1) % len(b.events)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// oldest returns the sequence of the oldest buffered event, 0 when empty
+func (b *replayBuffer) oldest() uint64 {
+	if b.full {
+		return b.events[b.next].seq
+	}
+	if b.next == 0 {
+		return 0
+	}
+	return b.events[0].seq
+}
+
+// since returns the buffered events after seq, oldest first. ok is false
+// when events after seq were already dropped from the buffer.
+func (b *replayBuffer) since(seq uint64) (events []outbound, ok bool) {
+	oldest := b.oldest()
+	if oldest == 0 {
+		return nil, true
+	}
+	if seq+1 < oldest {
+		return nil, false
+	}
+
+	count := b.next
+	start := 0
+	if b.full {
+		count = len(b.events)
+		start = b.next
+	}
+	for i := 0; i < count; i++ {
+		ev := b.events[(start+i)%len(b.events)]
+		if ev.seq > seq {
+			events = append(events, ev)
+		}
+	}
+	return events, true
+}
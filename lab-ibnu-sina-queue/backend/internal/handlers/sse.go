@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"lab-ibnu-sina-queue/internal/auth"
+)
+
+// ServeSSE streams the hub's events as Server-Sent Events, for networks and
+// old TV browsers where WebSocket upgrades fail. Clients are registered with
+// the hub like WebSocket clients, so they get the same snapshot, topics and
+// permission filtering.
+//
+// Event IDs are "<epoch>:<seq>", so the Last-Event-ID the browser sends when
+// it reconnects resumes the stream like last_seq does for WebSockets.
+func ServeSSE(hub *Hub, w http.ResponseWriter, r *http.Request, identity auth.Identity, ip string) {
+	client, err := newClient(hub, r, identity, ip)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	client.transport = "sse"
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Keep reverse proxies such as nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+	epoch, seq, _ := strings.Cut(lastID, ":")
+	client.resume(epoch, seq)
+
+	hub.register <- client
+	defer func() {
+		hub.unregister <- client
+	}()
+
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case message, ok := <-client.send:
+			if !ok {
+				// The hub dropped the client
+				return
+			}
+			rc.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := writeSSE(w, hub.epoch, message); err != nil {
+				return
+			}
+			client.sent.Add(1)
+		case <-ticker.C:
+			// Comment lines keep proxies from closing an idle stream
+			rc.SetWriteDeadline(time.Now().Add(writeWait))
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return
+			}
+			client.alive()
+		case <-r.Context().Done():
+			return
+		}
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// writeSSE writes one event; its JSON is always a single line
+func writeSSE(w io.Writer, epoch string, message outbound) error {
+	if message.seq > 0 {
+		if _, err := fmt.Fprintf(w, "id: %s:%d\n", epoch, message.seq); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "data: %s\n\n", message.data)
+	return err
+}
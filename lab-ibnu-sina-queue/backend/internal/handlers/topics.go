@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"lab-ibnu-sina-queue/internal/auth"
+)
+
+// Topics a client can subscribe to. Events are tagged with the topics they
+// concern; events without topics (a queue reset, new display settings) go
+// to every subscriber.
+const (
+	// TopicAll receives every event the client is allowed to see. New
+	// connections start subscribed to it.
+	TopicAll = "*"
+
+	// TopicAdmin carries staff alerts such as kiosk bursts
+	TopicAdmin = "admin"
+)
+
+var ErrInvalidTopic = errors.New("invalid topic, expected *, admin, category:<id>, counter:<number>, branch:<code> or device:<id>")
+
+// CategoryTopic is the topic of events about tickets of a category
+func CategoryTopic(categoryID int) string {
+	return "category:" + strconv.Itoa(categoryID)
+}
+
+// CounterTopic is the topic of events about tickets at a counter
+func CounterTopic(counter int) string {
+	return "counter:" + strconv.Itoa(counter)
+}
+
+// BranchTopic is the topic of every event of a branch, set per server with
+// BRANCH_CODE
+func BranchTopic(code string) string {
+	return "branch:" + code
+}
+
+// DeviceTopic is the topic of commands for a single paired device. Unlike
+// other topics it is not included in * or the branch topic.
+func DeviceTopic(deviceID int) string {
+	return "device:" + strconv.Itoa(deviceID)
+}
+
+// validateTopic checks a topic name and whether identity may subscribe to it
+func validateTopic(identity auth.Identity, topic string) error {
+	switch topic {
+	case TopicAll:
+		return nil
+	case TopicAdmin:
+		if !identity.Can(auth.PermQueueView) {
+			return errors.New("the admin topic is for staff only")
+		}
+		return nil
+	}
+
+	kind, value, ok := strings.Cut(topic, ":")
+	if !ok || value == "" {
+		return ErrInvalidTopic
+	}
+	switch kind {
+	case "category", "counter":
+		if n, err := strconv.Atoi(value); err != nil || n < 0 {
+			return ErrInvalidTopic
+		}
+		return nil
+	case "branch":
+		return nil
+	case "device":
+		if value != strconv.Itoa(identity.DeviceID) {
+			return errors.New("devices can only subscribe to their own topic")
+		}
+		return nil
+	}
+	return ErrInvalidTopic
+}
+
+// matchTopics reports whether a client subscribed to subscribed should
+// receive an event tagged with topics. Subscribing to the server's branch
+// is the same as subscribing to everything, except for events addressed to
+// devices.
+func matchTopics(subscribed map[string]bool, topics []string, branch string) bool {
+	addressed := false
+	for _, topic := range topics {
+		if strings.HasPrefix(topic, "device:") {
+			if subscribed[topic] {
+				return true
+			}
+			addressed = true
+		}
+	}
+	if addressed {
+		return false
+	}
+	if subscribed[TopicAll] || (branch != "" && subscribed[BranchTopic(branch)]) {
+		return true
+	}
+	if len(topics) == 0 {
+		return len(subscribed) > 0
+	}
+	for _, topic := range topics {
+		if subscribed[topic] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"lab-ibnu-sina-queue/internal/auth"
+
+	"github.com/gorilla/websocket"
+)
+
+// dialHub starts a hub and connects a display to it over a real WebSocket,
+// subscribed to the given topics
+func dialHub(t *testing.T, topics string) (*Hub, *websocket.Conn) {
+	t.Helper()
+
+	hub := NewHub()
+	go hub.Run()
+
+	identity := auth.Identity{Username: "display", Role: auth.RoleDisplay}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ServeWs(hub, w, r, identity, "127.0.0.1")
+	}))
+	t.Cleanup(server.Close)
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?topics=" + topics
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return hub, conn
+}
+
+// readEvent returns the next event sent to conn
+func readEvent(t *testing.T, conn *websocket.Conn) Event {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	var event Event
+	if err := json.Unmarshal(data, &event); err != nil {
+		t.Fatalf("decode %s: %v", data, err)
+	}
+	return event
+}
+
+// changeTopics sends a SUBSCRIBE or UNSUBSCRIBE and waits for the hub to
+// confirm it
+func changeTopics(t *testing.T, conn *websocket.Conn, kind string, topics ...string) {
+	t.Helper()
+
+	if err := conn.WriteJSON(clientMessage{Type: kind, Topics: topics}); err != nil {
+		t.Fatalf("write %s: %v", kind, err)
+	}
+	if event := readEvent(t, conn); event.Type != EventSubscribed {
+		t.Fatalf("%s answered with %s, want %s", kind, event.Type, EventSubscribed)
+	}
+}
+
+// nextCall publishes a CALL_TICKET with the given topics followed by a
+// NEW_TICKET the client always receives, and reports whether the call
+// arrived before it
+func nextCall(t *testing.T, hub *Hub, conn *websocket.Conn, topics ...string) bool {
+	t.Helper()
+
+	hub.Publish(EventCallTicket, map[string]int{"id": 1}, topics...)
+	hub.Publish(EventNewTicket, map[string]int{"id": 2}, CategoryTopic(1))
+
+	event := readEvent(t, conn)
+	if event.Type == EventCallTicket {
+		if next := readEvent(t, conn); next.Type != EventNewTicket {
+			t.Fatalf("got %s after the call, want %s", next.Type, EventNewTicket)
+		}
+		return true
+	}
+	if event.Type != EventNewTicket {
+		t.Fatalf("got %s, want %s or %s", event.Type, EventCallTicket, EventNewTicket)
+	}
+	return false
+}
+
+func TestChangeTopicsMidConnection(t *testing.T) {
+	tests := []struct {
+		name   string
+		topic  string
+		topics []string
+	}{
+		{"category", CategoryTopic(2), []string{CategoryTopic(2)}},
+		{"counter", CounterTopic(3), []string{CategoryTopic(4), CounterTopic(3)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub, conn := dialHub(t, CategoryTopic(1))
+
+			// Waiting for SUBSCRIBED also makes sure the client is registered
+			changeTopics(t, conn, "SUBSCRIBE", CategoryTopic(1))
+			if nextCall(t, hub, conn, tt.topics...) {
+				t.Fatalf("call for %v delivered before subscribing to %s", tt.topics, tt.topic)
+			}
+
+			changeTopics(t, conn, "SUBSCRIBE", tt.topic)
+			if !nextCall(t, hub, conn, tt.topics...) {
+				t.Fatalf("call for %v withheld after subscribing to %s", tt.topics, tt.topic)
+			}
+
+			changeTopics(t, conn, "UNSUBSCRIBE", tt.topic)
+			if nextCall(t, hub, conn, tt.topics...) {
+				t.Fatalf("call for %v delivered after unsubscribing from %s", tt.topics, tt.topic)
+			}
+		})
+	}
+}
+
+func TestSubscribeInvalidTopic(t *testing.T) {
+	hub, conn := dialHub(t, CategoryTopic(1))
+
+	if err := conn.WriteJSON(clientMessage{Type: "SUBSCRIBE", Topics: []string{"counter:x"}}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if event := readEvent(t, conn); event.Type != EventError {
+		t.Fatalf("got %s, want %s", event.Type, EventError)
+	}
+
+	// The failed change leaves the subscription as it was
+	if nextCall(t, hub, conn, CounterTopic(3)) {
+		t.Fatal("call delivered to a topic that was never subscribed")
+	}
+}
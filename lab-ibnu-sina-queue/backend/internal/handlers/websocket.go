@@ -1,19 +1,72 @@
 package handlers
 
 import (
+	"encoding/json"
 	"log"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"lab-ibnu-sina-queue/internal/auth"
 
 	"github.com/gorilla/websocket"
 )
 
+const (
+	// Time allowed to write a message to the peer.
+	writeWait = 10 * time.Second
+
+	// Time allowed to read the next pong message from the peer.
+	pongWait = 60 * time.Second
+
+	// Send pings to peer with this period. Must be less than pongWait.
+	pingPeriod = (pongWait * 9) / 10
+
+	// Maximum message size allowed from peer.
+	maxMessageSize = 4096
+
+	// Maximum events waiting for Run; see Dispatch.
+	maxPending = 4096
+)
+
 var Upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
-	// Allow all origins for local development
-	CheckOrigin: func(r *http.Request) bool {
+	CheckOrigin:     checkOrigin,
+}
+
+// allowedOrigins lists the origins (e.g. "https://antrian.example.com")
+// browsers may open the socket from. Empty means same origin only.
+var allowedOrigins []string
+
+// SetAllowedOrigins configures the origin allow-list of the upgrader
+func SetAllowedOrigins(origins []string) {
+	allowedOrigins = origins
+}
+
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		// Not a browser, authentication alone decides
 		return true
-	},
+	}
+
+	if len(allowedOrigins) == 0 {
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+
+	for _, allowed := range allowedOrigins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
 }
 
 // Hub maintains the set of active clients and broadcasts messages to the
@@ -22,74 +75,453 @@ type Hub struct {
 	// Registered clients.
 	clients map[*Client]bool
 
-	// Inbound messages from the clients.
-	broadcast chan []byte
+	// Events published by the handlers, waiting for Run. Publish only
+	// appends under the lock so handlers never wait for slow clients.
+	// pendingLost is set when events were discarded because Run fell
+	// behind, so clients must catch up with a snapshot.
+	pendingMu   sync.Mutex
+	pending     []published
+	pendingLost bool
+	wake        chan struct{}
 
 	// Register requests from the clients.
 	register chan *Client
 
 	// Unregister requests from clients.
 	unregister chan *Client
+
+	// Subscription changes requested by clients.
+	subscribe chan subscription
+
+	// Requests for the list of connected clients.
+	inspect chan chan []ClientInfo
+
+	// Snapshots built for clients that overflowed their buffer.
+	resynced chan resync
+
+	// Replies to commands, and the function executing them.
+	replies  chan clientReply
+	commands CommandFunc
+
+	// Record device registrations and heartbeats.
+	registerDevice RegisterFunc
+	heartbeat      HeartbeatFunc
+
+	// Finds the zone limiting what a display receives.
+	zoneLookup ZoneFunc
+
+	// What to do with clients that fall behind, unless they chose.
+	overflowPolicy OverflowPolicy
+
+	stats struct {
+		clients     atomic.Int64
+		published   atomic.Uint64
+		dropped     atomic.Uint64
+		resyncs     atomic.Uint64
+		disconnects atomic.Uint64
+		overflowed  atomic.Uint64
+	}
+
+	// Branch of this server; subscribing to its topic receives everything.
+	branch string
+
+	// Identifies this server run; sequence numbers restart with it.
+	epoch string
+
+	// Sequence of the last event and of the oldest replayable one. Written
+	// by Run, read by connecting clients.
+	lastSeq   atomic.Uint64
+	oldestSeq atomic.Uint64
+
+	// Recent events for clients resuming after a reconnect.
+	replay *replayBuffer
+
+	// Builds the state sent to clients too far behind to replay.
+	snapshot SnapshotFunc
+
+	// Carries published events to the hubs of all server instances.
+	broker Broker
+}
+
+// published is an event handed to Publish, not yet sequenced
+type published struct {
+	eventType EventType
+	data      interface{}
+	topics    []string
 }
 
+// subscription adds or removes topics of a client
+type subscription struct {
+	client *Client
+	topics []string
+	remove bool
+}
+
+// SnapshotFunc returns the current state visible to identity
+type SnapshotFunc func(identity auth.Identity) (interface{}, error)
+
 func NewHub() *Hub {
-	return &Hub{
-		broadcast:  make(chan []byte),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
-		clients:    make(map[*Client]bool),
+	h := &Hub{
+		wake:           make(chan struct{}, 1),
+		register:       make(chan *Client),
+		unregister:     make(chan *Client),
+		subscribe:      make(chan subscription),
+		inspect:        make(chan chan []ClientInfo),
+		resynced:       make(chan resync),
+		replies:        make(chan clientReply),
+		clients:        make(map[*Client]bool),
+		epoch:          strconv.FormatInt(time.Now().UnixNano(), 36),
+		replay:         newReplayBuffer(replayBufferSize),
+		overflowPolicy: OverflowSnapshot,
+	}
+	h.broker = LocalBroker{hub: h}
+	return h
+}
+
+// SetOverflowPolicy sets the policy of clients that do not choose one.
+// Call it before serving connections.
+func (h *Hub) SetOverflowPolicy(policy OverflowPolicy) {
+	if policy != "" {
+		h.overflowPolicy = policy
+	}
+}
+
+// Stats returns the hub counters
+func (h *Hub) Stats() HubStats {
+	h.pendingMu.Lock()
+	pending := len(h.pending)
+	h.pendingMu.Unlock()
+
+	return HubStats{
+		Clients:     int(h.stats.clients.Load()),
+		Published:   h.stats.published.Load(),
+		Pending:     pending,
+		Dropped:     h.stats.dropped.Load(),
+		Resyncs:     h.stats.resyncs.Load(),
+		Disconnects: h.stats.disconnects.Load(),
+		Overflowed:  h.stats.overflowed.Load(),
 	}
 }
 
+// SetSnapshot sets the function building SNAPSHOT events. Call it before
+// serving connections.
+func (h *Hub) SetSnapshot(fn SnapshotFunc) {
+	h.snapshot = fn
+}
+
+// SetBranch sets the branch code of this server. Call it before serving
+// connections.
+func (h *Hub) SetBranch(code string) {
+	h.branch = code
+}
+
 func (h *Hub) Run() {
 	for {
 		select {
 		case client := <-h.register:
 			h.clients[client] = true
-			log.Println("New Client Connected")
+			log.Printf("New Client Connected: %s (%s)", client.identity.Username, client.identity.Role)
+			h.catchUp(client)
 		case client := <-h.unregister:
 			if _, ok := h.clients[client]; ok {
 				delete(h.clients, client)
 				close(client.send)
 				log.Println("Client Disconnected")
 			}
-		case message := <-h.broadcast:
+		case sub := <-h.subscribe:
+			if _, ok := h.clients[sub.client]; ok {
+				h.updateTopics(sub)
+			}
+		case reply := <-h.inspect:
+			infos := make([]ClientInfo, 0, len(h.clients))
 			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(h.clients, client)
+				infos = append(infos, client.info())
+			}
+			reply <- infos
+		case r := <-h.replies:
+			if _, ok := h.clients[r.client]; ok {
+				h.reply(r.client, r.eventType, r.data)
+			}
+		case rs := <-h.resynced:
+			if _, ok := h.clients[rs.client]; ok {
+				h.finishResync(rs)
+			}
+		case <-h.wake:
+			events, lost := h.takePending()
+			if lost {
+				h.resyncAll()
+			}
+			for _, event := range events {
+				message, err := h.sequence(event)
+				if err != nil {
+					log.Printf("Error encoding %s event: %v", event.eventType, err)
+					continue
+				}
+				for client := range h.clients {
+					h.deliver(client, message)
+				}
+				if event.eventType == EventDeviceRevoked {
+					h.disconnectDevices(event.topics)
 				}
 			}
 		}
+		h.stats.clients.Store(int64(len(h.clients)))
+	}
+}
+
+// takePending removes and returns the events published since the last
+// call, and whether any were discarded in between
+func (h *Hub) takePending() ([]published, bool) {
+	h.pendingMu.Lock()
+	defer h.pendingMu.Unlock()
+
+	events, lost := h.pending, h.pendingLost
+	h.pending = nil
+	h.pendingLost = false
+	return events, lost
+}
+
+// sequence numbers and encodes an event and keeps it for replay
+func (h *Hub) sequence(event published) (outbound, error) {
+	seq := h.lastSeq.Load() + 1
+	data, err := json.Marshal(Event{
+		Seq:   seq,
+		Epoch: h.epoch,
+		Type:  event.eventType,
+		Time:  time.Now(),
+		Data:  event.data,
+	})
+	if err != nil {
+		return outbound{}, err
+	}
+
+	message := outbound{seq: seq, data: data, perm: permissionFor(event.eventType), topics: event.topics}
+	h.replay.add(message)
+	h.lastSeq.Store(seq)
+	h.oldestSeq.Store(h.replay.oldest())
+	h.stats.published.Add(1)
+	return message, nil
+}
+
+// deliver queues a message for a client allowed to see it. Clients waiting
+// for a resync snapshot get the message replayed after it instead.
+func (h *Hub) deliver(client *Client, message outbound) {
+	if client.resyncing {
+		return
+	}
+	if !client.identity.Can(message.perm) || !matchTopics(client.topics, message.topics, h.branch) {
+		return
+	}
+	if !client.zone.allows(message.topics) {
+		return
+	}
+	h.send(client, message)
+}
+
+// send queues a message for a client, applying its overflow policy if the
+// buffer is full
+func (h *Hub) send(client *Client, message outbound) {
+	select {
+	case client.send <- message:
+	default:
+		h.overflow(client, message)
+	}
+}
+
+// catchUp sends a newly registered client its snapshot and the events it
+// missed. If the missed events already left the buffer the client is
+// dropped, and reconnects to get a fresh snapshot.
+func (h *Hub) catchUp(client *Client) {
+	if client.initial != nil {
+		h.deliver(client, *client.initial)
+	}
+	if _, ok := h.clients[client]; !ok {
+		return
+	}
+
+	missed, ok := h.replay.since(client.resumeFrom)
+	if !ok {
+		log.Printf("Client %s is too far behind, disconnecting", client.identity.Username)
+		h.stats.disconnects.Add(1)
+		close(client.send)
+		delete(h.clients, client)
+		return
+	}
+	for _, message := range missed {
+		if _, ok := h.clients[client]; !ok {
+			return
+		}
+		h.deliver(client, message)
+	}
+}
+
+// updateTopics applies a subscription change and confirms the resulting
+// topics to the client
+func (h *Hub) updateTopics(sub subscription) {
+	for _, topic := range sub.topics {
+		if err := validateTopic(sub.client.identity, topic); err != nil {
+			h.reply(sub.client, EventError, map[string]string{"message": err.Error(), "topic": topic})
+			return
+		}
+	}
+
+	for _, topic := range sub.topics {
+		if sub.remove {
+			delete(sub.client.topics, topic)
+		} else {
+			sub.client.topics[topic] = true
+		}
+	}
+
+	topics := make([]string, 0, len(sub.client.topics))
+	for topic := range sub.client.topics {
+		topics = append(topics, topic)
 	}
+	sort.Strings(topics)
+	h.reply(sub.client, EventSubscribed, map[string][]string{"topics": topics})
 }
 
-// Broadcast sends a message to all connected clients
-func (h *Hub) BroadcastMessage(msg []byte) {
-	h.broadcast <- msg
+// reply sends an unsequenced message to a single client
+func (h *Hub) reply(client *Client, eventType EventType, data interface{}) {
+	msg, err := json.Marshal(Event{Type: eventType, Time: time.Now(), Data: data})
+	if err != nil {
+		log.Printf("Error encoding %s reply: %v", eventType, err)
+		return
+	}
+	h.send(client, outbound{data: msg})
+}
+
+// canResume reports whether the events after seq can still be replayed
+func (h *Hub) canResume(epoch string, seq uint64) bool {
+	if epoch != h.epoch || seq > h.lastSeq.Load() {
+		return false
+	}
+	oldest := h.oldestSeq.Load()
+	return oldest == 0 || seq+1 >= oldest
+}
+
+// snapshotEvent encodes the current state for identity as of seq
+func (h *Hub) snapshotEvent(identity auth.Identity, seq uint64) (*outbound, error) {
+	if h.snapshot == nil {
+		return nil, nil
+	}
+	state, err := h.snapshot(identity)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(Event{
+		Seq:   seq,
+		Epoch: h.epoch,
+		Type:  EventSnapshot,
+		Time:  time.Now(),
+		Data:  state,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &outbound{seq: seq, data: data, perm: permissionFor(EventSnapshot)}, nil
+}
+
+// Publish sends an event to every connected client allowed to see it and
+// subscribed to one of its topics. Events without topics go to everyone.
+// The event goes through the broker, so clients of other server instances
+// receive it too. It never blocks on the hub or on slow clients.
+func (h *Hub) Publish(eventType EventType, data interface{}, topics ...string) {
+	h.broker.Publish(BrokerEvent{Type: eventType, Data: data, Topics: topics})
+}
+
+// Dispatch hands an event to this hub's clients only. Brokers call it for
+// events published locally and by other instances. At most maxPending
+// events wait for Run; past that the hub's overflow policy decides: with
+// drop-oldest the oldest waiting event is discarded, otherwise all of them
+// are and every client catches up as its own policy says.
+func (h *Hub) Dispatch(event BrokerEvent) {
+	h.pendingMu.Lock()
+	if len(h.pending) >= maxPending {
+		if h.overflowPolicy == OverflowDropOldest {
+			h.stats.overflowed.Add(1)
+			h.pending = append(h.pending[:0], h.pending[1:]...)
+		} else {
+			h.stats.overflowed.Add(uint64(len(h.pending)))
+			h.pending = nil
+			h.pendingLost = true
+		}
+	}
+	h.pending = append(h.pending, published{eventType: event.Type, data: event.Data, topics: event.Topics})
+	h.pendingMu.Unlock()
+
+	select {
+	case h.wake <- struct{}{}:
+	default:
+		// Run is already due to drain the pending events
+	}
 }
 
 // Client is a middleman between the websocket connection and the hub.
 type Client struct {
 	hub *Hub
 
-	// The websocket connection.
-	conn *websocket.Conn
+	// The authenticated user or device behind the connection.
+	identity auth.Identity
+
+	// Subscribed topics, only touched by Hub.Run.
+	topics map[string]bool
+
+	// The zone of a display, fixed for the connection; nil for none.
+	zone *ZoneFilter
+
+	// The websocket connection, nil for SSE clients.
+	conn      *websocket.Conn
+	transport string
 
 	// Buffered channel of outbound messages.
-	send chan []byte
+	send chan outbound
+
+	// Events after resumeFrom are replayed on registration, after the
+	// initial snapshot if there is one.
+	resumeFrom uint64
+	initial    *outbound
+
+	// What to do when send is full, and whether a resync snapshot is
+	// being built. resyncing is only touched by Hub.Run.
+	overflow  OverflowPolicy
+	resyncing bool
+
+	// Connection details shown to admins.
+	ip          string
+	userAgent   string
+	connectedAt time.Time
+	lastPong    atomic.Int64
+	sent        atomic.Uint64
+	dropped     atomic.Uint64
+
+	// What a device reported with REGISTER, nil until then.
+	registration atomic.Pointer[Registration]
 }
 
-// ServeWs handles websocket requests from the peer.
-func ServeWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
+// ServeWs handles websocket requests from an authenticated peer. New clients
+// first receive a SNAPSHOT of the current state. A client reconnecting
+// passes the epoch and last_seq of the last event it saw to receive just the
+// events it missed, or a snapshot if they are no longer kept. Initial topics
+// can be given as a comma separated topics parameter, and the overflow policy
+// as overflow.
+func ServeWs(hub *Hub, w http.ResponseWriter, r *http.Request, identity auth.Identity, ip string) {
+	query := r.URL.Query()
+	client, err := newClient(hub, r, identity, ip)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	conn, err := Upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println(err)
 		return
 	}
-	client := &Client{hub: hub, conn: conn, send: make(chan []byte, 256)}
+	client.conn = conn
+	client.transport = "websocket"
+
+	client.resume(query.Get("epoch"), query.Get("last_seq"))
 	client.hub.register <- client
 
 	// Allow collection of memory referenced by the caller by doing all work in
@@ -98,52 +530,143 @@ func ServeWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
 	go client.readPump()
 }
 
+// newClient reads the connect parameters shared by the WebSocket and SSE
+// endpoints
+func newClient(hub *Hub, r *http.Request, identity auth.Identity, ip string) (*Client, error) {
+	query := r.URL.Query()
+
+	overflow, err := ParseOverflowPolicy(query.Get("overflow"))
+	if err != nil {
+		return nil, err
+	}
+	if overflow == "" {
+		overflow = hub.overflowPolicy
+	}
+
+	topics := map[string]bool{TopicAll: true}
+	if list := query.Get("topics"); list != "" {
+		topics = make(map[string]bool)
+		for _, topic := range strings.Split(list, ",") {
+			topic = strings.TrimSpace(topic)
+			if err := validateTopic(identity, topic); err != nil {
+				return nil, err
+			}
+			topics[topic] = true
+		}
+	}
+
+	// Paired devices always receive the commands sent to them
+	var zone *ZoneFilter
+	if identity.DeviceID != 0 {
+		topics[DeviceTopic(identity.DeviceID)] = true
+
+		if hub.zoneLookup != nil {
+			if zone, err = hub.zoneLookup(identity); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &Client{
+		hub:         hub,
+		identity:    identity,
+		topics:      topics,
+		zone:        zone,
+		overflow:    overflow,
+		send:        make(chan outbound, 256),
+		ip:          ip,
+		userAgent:   r.UserAgent(),
+		connectedAt: time.Now(),
+	}, nil
+}
+
+// resume sets up what the client gets on registration: the events after
+// lastSeq if they can be replayed, otherwise a snapshot and the events
+// after it
+func (c *Client) resume(epoch, lastSeq string) {
+	seq, err := strconv.ParseUint(lastSeq, 10, 64)
+	if err == nil && c.hub.canResume(epoch, seq) {
+		c.resumeFrom = seq
+		return
+	}
+
+	// Take the sequence before reading state so nothing published in
+	// between is lost; those events are replayed after the snapshot
+	seq = c.hub.lastSeq.Load()
+	initial, err := c.hub.snapshotEvent(c.identity, seq)
+	if err != nil {
+		log.Printf("Error building snapshot: %v", err)
+	}
+	c.initial = initial
+	c.resumeFrom = seq
+}
+
 // readPump pumps messages from the websocket connection to the hub.
 func (c *Client) readPump() {
 	defer func() {
 		c.hub.unregister <- c
 		c.conn.Close()
 	}()
+	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		c.alive()
+		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
 	for {
-		_, _, err := c.conn.ReadMessage()
+		_, data, err := c.conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("error: %v", err)
 			}
 			break
 		}
-		// Currently we don't expect messages FROM the client, only push TO them.
-		// using broadcast for simple echo if needed, or ignore.
+
+		var msg clientMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			continue
+		}
+		switch msg.Type {
+		case "SUBSCRIBE":
+			c.hub.subscribe <- subscription{client: c, topics: msg.Topics}
+		case "UNSUBSCRIBE":
+			c.hub.subscribe <- subscription{client: c, topics: msg.Topics, remove: true}
+		case "COMMAND":
+			c.runCommand(msg)
+		case "REGISTER":
+			c.runRegister(msg)
+		}
 	}
 }
 
 // writePump pumps messages from the hub to the websocket connection.
+//
+// Every message goes in its own frame so the client can parse each one, and
+// pings are sent periodically to detect half-open connections.
 func (c *Client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
 	defer func() {
+		ticker.Stop()
 		c.conn.Close()
 	}()
 	for {
 		select {
 		case message, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if !ok {
 				// The hub closed the channel.
 				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
 
-			w, err := c.conn.NextWriter(websocket.TextMessage)
-			if err != nil {
+			if err := c.conn.WriteMessage(websocket.TextMessage, message.data); err != nil {
 				return
 			}
-			w.Write(message)
-
-			// Add queued chat messages to the current websocket message.
-			n := len(c.send)
-			for i := 0; i < n; i++ {
-				w.Write(<-c.send)
-			}
-
-			if err := w.Close(); err != nil {
+			c.sent.Add(1)
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
 		}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"lab-ibnu-sina-queue/internal/auth"
+)
+
+func TestDispatchCapsPending(t *testing.T) {
+	tests := []struct {
+		policy       OverflowPolicy
+		wantPending  int
+		wantFirst    int
+		wantLost     bool
+		wantOverflow uint64
+	}{
+		{OverflowDropOldest, maxPending, 1, false, 1},
+		{OverflowSnapshot, 1, maxPending, true, maxPending},
+		{OverflowDisconnect, 1, maxPending, true, maxPending},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			hub := NewHub()
+			hub.SetOverflowPolicy(tt.policy)
+			for i := 0; i <= maxPending; i++ {
+				hub.Dispatch(BrokerEvent{Type: EventCallTicket, Data: i})
+			}
+
+			events, lost := hub.takePending()
+			if len(events) != tt.wantPending || lost != tt.wantLost {
+				t.Fatalf("pending = %d, lost %v; want %d, lost %v", len(events), lost, tt.wantPending, tt.wantLost)
+			}
+			if first := events[0].data; first != tt.wantFirst {
+				t.Errorf("first pending event = %v, want %d", first, tt.wantFirst)
+			}
+			if got := hub.Stats().Overflowed; got != tt.wantOverflow {
+				t.Errorf("Overflowed = %d, want %d", got, tt.wantOverflow)
+			}
+		})
+	}
+}
+
+// BenchmarkPublish measures the time from publishing an event until a
+// client has it, with the other clients reading in the background. The
+// time the handler itself waits in Publish is reported as publish-ns/op;
+// it should stay the same whatever the number of connected clients.
+func BenchmarkPublish(b *testing.B) {
+	for _, n := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprintf("clients=%d", n), func(b *testing.B) {
+			hub := NewHub()
+			go hub.Run()
+
+			newDisplay := func() *Client {
+				client := &Client{
+					hub:      hub,
+					identity: auth.Identity{Username: "display", Role: auth.RoleDisplay},
+					topics:   map[string]bool{TopicAll: true},
+					overflow: OverflowDropOldest,
+					send:     make(chan outbound, 256),
+				}
+				hub.register <- client
+				return client
+			}
+
+			// The probe is read by the benchmark, the others like connected
+			// clients until the hub closes send
+			probe := newDisplay()
+			clients := []*Client{probe}
+			for i := 1; i < n; i++ {
+				client := newDisplay()
+				go func() {
+					for range client.send {
+					}
+				}()
+				clients = append(clients, client)
+			}
+
+			data := map[string]interface{}{"id": 1, "formatted_code": "A-001", "counter": 1}
+			var publishing time.Duration
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				start := time.Now()
+				hub.Publish(EventCallTicket, data, CategoryTopic(1), CounterTopic(1))
+				publishing += time.Since(start)
+				<-probe.send
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(publishing.Nanoseconds())/float64(b.N), "publish-ns/op")
+
+			for _, client := range clients {
+				hub.unregister <- client
+			}
+		})
+	}
+}
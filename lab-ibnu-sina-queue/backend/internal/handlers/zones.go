@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	"lab-ibnu-sina-queue/internal/auth"
+)
+
+// ZoneFilter limits a display to events about tickets of some categories
+// and counters, on top of its topics. Unlike topics it is set by the
+// server from the device's zone, not chosen by the client. Empty lists
+// allow all.
+type ZoneFilter struct {
+	Categories []int
+	Counters   []int
+}
+
+// ZoneFunc returns the filter of a connecting client, nil for none
+type ZoneFunc func(identity auth.Identity) (*ZoneFilter, error)
+
+// SetZoneLookup sets the function finding the zone of connecting clients.
+// Call it before serving connections.
+func (h *Hub) SetZoneLookup(fn ZoneFunc) {
+	h.zoneLookup = fn
+}
+
+// allows reports whether an event tagged with topics passes the filter.
+// Events without category or counter topics, such as a queue reset, always
+// pass.
+func (f *ZoneFilter) allows(topics []string) bool {
+	if f == nil {
+		return true
+	}
+	for _, topic := range topics {
+		kind, value, _ := strings.Cut(topic, ":")
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			continue
+		}
+		switch {
+		case kind == "category" && len(f.Categories) > 0 && !slices.Contains(f.Categories, n):
+			return false
+		case kind == "counter" && len(f.Counters) > 0 && !slices.Contains(f.Counters, n):
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,120 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrKeyReused is returned when a key is sent again with a different request
+var ErrKeyReused = errors.New("idempotency key was already used for a different request")
+
+// Response is a stored handler response replayed for retries
+type Response struct {
+	Status      int
+	ContentType string
+	Body        []byte
+}
+
+type entry struct {
+	fingerprint string
+	done        chan struct{}
+	response    *Response
+	expires     time.Time
+}
+
+// Store keeps responses per idempotency key for a limited time
+type Store struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	entries   map[string]*entry
+	lastSweep time.Time
+}
+
+func NewStore(ttl time.Duration) *Store {
+	return &Store{ttl: ttl, entries: make(map[string]*entry)}
+}
+
+// Begin claims a key. If the key was seen before, the stored response is
+// returned (waiting for a request still in flight). Otherwise the caller
+// owns the key and must call Complete or Abort.
+func (s *Store) Begin(ctx context.Context, key, fingerprint string) (*Response, bool, error) {
+	now := time.Now()
+	s.mu.Lock()
+	s.sweep(now)
+
+	e, ok := s.entries[key]
+	// Sweeps run once a minute, so an expired response may still be here
+	if ok && e.response != nil && now.After(e.expires) {
+		ok = false
+	}
+	if !ok {
+		s.entries[key] = &entry{fingerprint: fingerprint, done: make(chan struct{})}
+		s.mu.Unlock()
+		return nil, true, nil
+	}
+	s.mu.Unlock()
+
+	if e.fingerprint != fingerprint {
+		return nil, false, ErrKeyReused
+	}
+
+	// A retry raced the original request; wait for its outcome
+	select {
+	case <-e.done:
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	}
+
+	if e.response == nil {
+		// The original failed and released the key, so try again
+		return s.Begin(ctx, key, fingerprint)
+	}
+	return e.response, false, nil
+}
+
+// Complete stores the response of an owned key
+func (s *Store) Complete(key string, resp *Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.entries[key]; ok {
+		e.response = resp
+		e.expires = time.Now().Add(s.ttl)
+		close(e.done)
+	}
+}
+
+// Abort releases an owned key without storing anything, so a retry runs the
+// handler again (used for server errors)
+func (s *Store) Abort(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.entries[key]; ok {
+		delete(s.entries, key)
+		close(e.done)
+	}
+}
+
+// sweep drops expired responses at most once a minute
+func (s *Store) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+
+	for key, e := range s.entries {
+		if e.response != nil && now.After(e.expires) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+// Cacheable reports whether a response status should be replayed. Server
+// errors and rate limiting are transient, so retries should run again.
+func Cacheable(status int) bool {
+	return status < http.StatusInternalServerError && status != http.StatusTooManyRequests
+}
@@ -0,0 +1,138 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBeginReplaysCompletedResponse(t *testing.T) {
+	s := NewStore(time.Minute)
+	ctx := context.Background()
+
+	if _, owner, err := s.Begin(ctx, "k", "fp"); err != nil || !owner {
+		t.Fatalf("first Begin = owner %v, %v; want owner", owner, err)
+	}
+	s.Complete("k", &Response{Status: http.StatusCreated, Body: []byte("A-001")})
+
+	resp, owner, err := s.Begin(ctx, "k", "fp")
+	if err != nil || owner {
+		t.Fatalf("retry Begin = owner %v, %v; want a replay", owner, err)
+	}
+	if resp.Status != http.StatusCreated || string(resp.Body) != "A-001" {
+		t.Errorf("replayed %d %q, want 201 A-001", resp.Status, resp.Body)
+	}
+}
+
+func TestBeginKeys(t *testing.T) {
+	tests := []struct {
+		name      string
+		key, fp   string
+		wantOwner bool
+		wantErr   error
+	}{
+		{"same key, other request", "k", "other", false, ErrKeyReused},
+		{"other key, same request", "k2", "fp", true, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewStore(time.Minute)
+			s.Begin(context.Background(), "k", "fp")
+			s.Complete("k", &Response{Status: http.StatusOK})
+
+			_, owner, err := s.Begin(context.Background(), tt.key, tt.fp)
+			if owner != tt.wantOwner || !errors.Is(err, tt.wantErr) {
+				t.Errorf("Begin = owner %v, %v; want owner %v, %v", owner, err, tt.wantOwner, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBeginAfterExpiry(t *testing.T) {
+	s := NewStore(10 * time.Millisecond)
+	ctx := context.Background()
+
+	s.Begin(ctx, "k", "fp")
+	s.Complete("k", &Response{Status: http.StatusOK})
+	time.Sleep(20 * time.Millisecond)
+
+	if _, owner, err := s.Begin(ctx, "k", "fp"); err != nil || !owner {
+		t.Errorf("Begin after the TTL = owner %v, %v; want a fresh owner", owner, err)
+	}
+}
+
+func TestBeginWhileInFlight(t *testing.T) {
+	tests := []struct {
+		name      string
+		finish    func(s *Store)
+		wantOwner bool
+		wantResp  bool
+	}{
+		{"original completes", func(s *Store) { s.Complete("k", &Response{Status: http.StatusOK}) }, false, true},
+		{"original aborts", func(s *Store) { s.Abort("k") }, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewStore(time.Minute)
+			s.Begin(context.Background(), "k", "fp")
+
+			type result struct {
+				resp  *Response
+				owner bool
+				err   error
+			}
+			done := make(chan result)
+			go func() {
+				resp, owner, err := s.Begin(context.Background(), "k", "fp")
+				done <- result{resp, owner, err}
+			}()
+
+			select {
+			case <-done:
+				t.Fatal("retry returned while the original was in flight")
+			case <-time.After(20 * time.Millisecond):
+			}
+
+			tt.finish(s)
+			r := <-done
+			if r.err != nil || r.owner != tt.wantOwner || (r.resp != nil) != tt.wantResp {
+				t.Errorf("retry = resp %v, owner %v, %v; want resp %v, owner %v", r.resp, r.owner, r.err, tt.wantResp, tt.wantOwner)
+			}
+		})
+	}
+}
+
+func TestBeginInFlightConflictTimesOut(t *testing.T) {
+	s := NewStore(time.Minute)
+	s.Begin(context.Background(), "k", "fp")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, owner, err := s.Begin(ctx, "k", "fp"); owner || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Begin = owner %v, %v; want %v", owner, err, context.DeadlineExceeded)
+	}
+}
+
+func TestCacheable(t *testing.T) {
+	tests := []struct {
+		status int
+		want   bool
+	}{
+		{http.StatusOK, true},
+		{http.StatusCreated, true},
+		{http.StatusConflict, true},
+		{http.StatusTooManyRequests, false},
+		{http.StatusInternalServerError, false},
+		{http.StatusServiceUnavailable, false},
+	}
+
+	for _, tt := range tests {
+		if got := Cacheable(tt.status); got != tt.want {
+			t.Errorf("Cacheable(%d) = %v, want %v", tt.status, got, tt.want)
+		}
+	}
+}
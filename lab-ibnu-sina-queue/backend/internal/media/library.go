@@ -0,0 +1,201 @@
+package media
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"lab-ibnu-sina-queue/internal/database"
+)
+
+var (
+	ErrUnsupportedType = errors.New("unsupported media type, expected MP4 or WebM video, or JPEG, PNG, GIF or WebP image")
+	ErrTooLarge        = errors.New("media file is too large")
+)
+
+// URLPrefix is where stored files are served from
+const URLPrefix = "/media/"
+
+// allowedTypes maps the sniffed content types that may be uploaded to the
+// extension they are stored with
+var allowedTypes = map[string]string{
+	"video/mp4":  ".mp4",
+	"video/webm": ".webm",
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// storedNamePattern matches the names files are stored under, so requests
+// can never point outside the media directory
+var storedNamePattern = regexp.MustCompile(`^[0-9a-f]{64}\.[a-z0-9]+$`)
+
+// File is an uploaded video or image. Files are stored under the SHA-256 of
+// their content, so uploading the same file twice stores it once and the
+// URL of a file never changes.
+type File struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	StoredName  string    `json:"stored_name"`
+	URL         string    `json:"url"`
+	ContentType string    `json:"content_type"`
+	Kind        string    `json:"kind"`
+	Size        int64     `json:"size"`
+	UploadedBy  string    `json:"uploaded_by"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+const fileColumns = `id, name, stored_name, content_type, size_bytes, uploaded_by, created_at`
+
+func scanFile(row interface{ Scan(...interface{}) error }) (File, error) {
+	var f File
+	err := row.Scan(&f.ID, &f.Name, &f.StoredName, &f.ContentType, &f.Size, &f.UploadedBy, &f.CreatedAt)
+	f.URL = URLPrefix + f.StoredName
+	f.Kind, _, _ = strings.Cut(f.ContentType, "/")
+	return f, err
+}
+
+// Library stores uploaded files in a directory and their details in the
+// database
+type Library struct {
+	dir     string
+	maxSize int64
+}
+
+// NewLibrary uses dir for storage, creating it if needed, and rejects files
+// larger than maxSize bytes
+func NewLibrary(dir string, maxSize int64) (*Library, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Library{dir: dir, maxSize: maxSize}, nil
+}
+
+// MaxSize is the largest file accepted, in bytes
+func (l *Library) MaxSize() int64 {
+	return l.maxSize
+}
+
+// Save stores the content read from r. The type is detected from the
+// content itself, not trusted from the client.
+func (l *Library) Save(name string, r io.Reader, uploadedBy string) (File, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return File{}, ErrUnsupportedType
+	}
+	head = head[:n]
+
+	contentType := http.DetectContentType(head)
+	ext, ok := allowedTypes[contentType]
+	if !ok {
+		return File{}, ErrUnsupportedType
+	}
+
+	tmp, err := os.CreateTemp(l.dir, ".upload-*")
+	if err != nil {
+		return File{}, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), io.LimitReader(io.MultiReader(bytes.NewReader(head), r), l.maxSize+1))
+	if err != nil {
+		return File{}, err
+	}
+	if size > l.maxSize {
+		return File{}, ErrTooLarge
+	}
+	if err := tmp.Close(); err != nil {
+		return File{}, err
+	}
+
+	storedName := hex.EncodeToString(hash.Sum(nil)) + ext
+	if existing, err := scanFile(database.DB.QueryRow(`SELECT `+fileColumns+` FROM media_files WHERE stored_name = ?`, storedName)); err == nil {
+		return existing, nil
+	}
+
+	if err := os.Rename(tmp.Name(), filepath.Join(l.dir, storedName)); err != nil {
+		return File{}, err
+	}
+
+	res, err := database.DB.Exec(`
+		INSERT INTO media_files (name, stored_name, content_type, size_bytes, uploaded_by)
+		VALUES (?, ?, ?, ?, ?)
+	`, filepath.Base(name), storedName, contentType, size, uploadedBy)
+	if err != nil {
+		return File{}, err
+	}
+
+	id, _ := res.LastInsertId()
+	return Get(int(id))
+}
+
+// Open returns a stored file for serving
+func (l *Library) Open(storedName string) (*os.File, File, error) {
+	if !storedNamePattern.MatchString(storedName) {
+		return nil, File{}, sql.ErrNoRows
+	}
+
+	f, err := scanFile(database.DB.QueryRow(`SELECT `+fileColumns+` FROM media_files WHERE stored_name = ?`, storedName))
+	if err != nil {
+		return nil, File{}, err
+	}
+
+	file, err := os.Open(filepath.Join(l.dir, storedName))
+	if err != nil {
+		return nil, File{}, err
+	}
+	return file, f, nil
+}
+
+// Delete removes a file and its details
+func (l *Library) Delete(id int) error {
+	f, err := Get(id)
+	if err != nil {
+		return err
+	}
+
+	if _, err := database.DB.Exec(`DELETE FROM media_files WHERE id = ?`, id); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(l.dir, f.StoredName)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Get finds a file by ID
+func Get(id int) (File, error) {
+	return scanFile(database.DB.QueryRow(`SELECT `+fileColumns+` FROM media_files WHERE id = ?`, id))
+}
+
+// List returns every stored file, newest first
+func List() ([]File, error) {
+	rows, err := database.DB.Query(`SELECT ` + fileColumns + ` FROM media_files ORDER BY id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := []File{}
+	for rows.Next() {
+		f, err := scanFile(rows)
+		if err != nil {
+			continue
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
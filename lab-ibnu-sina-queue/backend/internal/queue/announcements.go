@@ -0,0 +1,216 @@
+package queue
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"lab-ibnu-sina-queue/internal/database"
+)
+
+var ErrInvalidAnnouncement = errors.New("invalid announcement")
+
+// Announcement priorities, lowest first. Urgent messages are also shown as
+// a banner on the displays.
+var announcementPriorities = map[string]bool{
+	"normal": true,
+	"high":   true,
+	"urgent": true,
+}
+
+// Announcement is a notice shown in the displays' running text between
+// StartsAt and EndsAt. DeviceIDs limits it to some displays; empty means
+// every display.
+type Announcement struct {
+	ID        int        `json:"id"`
+	Text      string     `json:"text"`
+	Priority  string     `json:"priority"`
+	StartsAt  time.Time  `json:"starts_at"`
+	EndsAt    *time.Time `json:"ends_at,omitempty"`
+	DeviceIDs []int      `json:"device_ids"`
+	CreatedBy string     `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// For reports whether a display with the device ID shows the announcement.
+// Displays that are not paired devices only show untargeted ones.
+func (a Announcement) For(deviceID int) bool {
+	if len(a.DeviceIDs) == 0 {
+		return true
+	}
+	for _, id := range a.DeviceIDs {
+		if id == deviceID {
+			return true
+		}
+	}
+	return false
+}
+
+// ActiveAt reports whether the announcement is shown at t
+func (a Announcement) ActiveAt(t time.Time) bool {
+	return !a.StartsAt.After(t) && (a.EndsAt == nil || a.EndsAt.After(t))
+}
+
+// Validate checks an announcement before it is stored
+func (a Announcement) Validate() error {
+	invalid := func(reason string) error {
+		return fmt.Errorf("%w: %s", ErrInvalidAnnouncement, reason)
+	}
+
+	switch {
+	case a.Text == "":
+		return invalid("text is required")
+	case len(a.Text) > 500:
+		return invalid("text is longer than 500 characters")
+	case !announcementPriorities[a.Priority]:
+		return invalid("priority must be normal, high or urgent")
+	case a.EndsAt != nil && !a.EndsAt.After(a.StartsAt):
+		return invalid("ends_at must be after starts_at")
+	}
+	return nil
+}
+
+const announcementColumns = `id, text, priority, starts_at, ends_at, device_ids, created_by, created_at`
+
+func scanAnnouncement(row interface{ Scan(...interface{}) error }) (Announcement, error) {
+	var a Announcement
+	var endsAt sql.NullTime
+	var deviceIDs string
+	err := row.Scan(&a.ID, &a.Text, &a.Priority, &a.StartsAt, &endsAt, &deviceIDs, &a.CreatedBy, &a.CreatedAt)
+	if endsAt.Valid {
+		a.EndsAt = &endsAt.Time
+	}
+	a.DeviceIDs = parseIntList(deviceIDs)
+	return a, err
+}
+
+func queryAnnouncements(query string, args ...interface{}) ([]Announcement, error) {
+	rows, err := database.DB.Query(`SELECT `+announcementColumns+` FROM announcements `+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	announcements := []Announcement{}
+	for rows.Next() {
+		a, err := scanAnnouncement(rows)
+		if err != nil {
+			continue
+		}
+		announcements = append(announcements, a)
+	}
+	return announcements, nil
+}
+
+// ListAnnouncements returns every announcement that has not expired,
+// including scheduled ones
+func ListAnnouncements() ([]Announcement, error) {
+	return queryAnnouncements(`WHERE ends_at IS NULL OR ends_at > ? ORDER BY starts_at, id`, time.Now())
+}
+
+// ActiveAnnouncements returns the announcements shown at now, most
+// important first
+func ActiveAnnouncements(now time.Time) ([]Announcement, error) {
+	return queryAnnouncements(`
+		WHERE starts_at <= ? AND (ends_at IS NULL OR ends_at > ?)
+		ORDER BY FIELD(priority, 'urgent', 'high', 'normal'), starts_at, id
+	`, now, now)
+}
+
+// GetAnnouncement finds an announcement by ID
+func GetAnnouncement(id int) (Announcement, error) {
+	return scanAnnouncement(database.DB.QueryRow(`SELECT `+announcementColumns+` FROM announcements WHERE id = ?`, id))
+}
+
+// CreateAnnouncement stores a new announcement. It is published right away
+// if it has already started; see StartDueAnnouncements otherwise.
+func CreateAnnouncement(a Announcement) (Announcement, error) {
+	if err := a.Validate(); err != nil {
+		return Announcement{}, err
+	}
+
+	res, err := database.DB.Exec(`
+		INSERT INTO announcements (text, priority, starts_at, ends_at, device_ids, created_by, started)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, a.Text, a.Priority, a.StartsAt, a.EndsAt, formatIntList(a.DeviceIDs), a.CreatedBy, !a.StartsAt.After(time.Now()))
+	if err != nil {
+		return Announcement{}, err
+	}
+
+	id, _ := res.LastInsertId()
+	return GetAnnouncement(int(id))
+}
+
+// UpdateAnnouncement replaces an announcement's text, priority, times and
+// targets
+func UpdateAnnouncement(a Announcement) (Announcement, error) {
+	if err := a.Validate(); err != nil {
+		return Announcement{}, err
+	}
+
+	_, err := database.DB.Exec(`
+		UPDATE announcements SET text = ?, priority = ?, starts_at = ?, ends_at = ?, device_ids = ?, started = ?
+		WHERE id = ?
+	`, a.Text, a.Priority, a.StartsAt, a.EndsAt, formatIntList(a.DeviceIDs), !a.StartsAt.After(time.Now()), a.ID)
+	if err != nil {
+		return Announcement{}, err
+	}
+
+	return GetAnnouncement(a.ID)
+}
+
+// DeleteAnnouncement removes an announcement
+func DeleteAnnouncement(id int) error {
+	res, err := database.DB.Exec(`DELETE FROM announcements WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// StartDueAnnouncements marks scheduled announcements whose start time has
+// passed as started and returns them, so they are published once
+func StartDueAnnouncements(now time.Time) ([]Announcement, error) {
+	due, err := queryAnnouncements(`WHERE started = FALSE AND starts_at <= ?`, now)
+	if err != nil {
+		return nil, err
+	}
+
+	var started []Announcement
+	for _, a := range due {
+		res, err := database.DB.Exec(`UPDATE announcements SET started = TRUE WHERE id = ? AND started = FALSE`, a.ID)
+		if err != nil {
+			return started, err
+		}
+		// Another server instance may have started it already
+		if n, _ := res.RowsAffected(); n > 0 && a.ActiveAt(now) {
+			started = append(started, a)
+		}
+	}
+	return started, nil
+}
+
+// DeleteExpiredAnnouncements removes announcements whose end time has
+// passed and returns them
+func DeleteExpiredAnnouncements(now time.Time) ([]Announcement, error) {
+	expired, err := queryAnnouncements(`WHERE ends_at IS NOT NULL AND ends_at <= ?`, now)
+	if err != nil {
+		return nil, err
+	}
+
+	var deleted []Announcement
+	for _, a := range expired {
+		res, err := database.DB.Exec(`DELETE FROM announcements WHERE id = ?`, a.ID)
+		if err != nil {
+			return deleted, err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			deleted = append(deleted, a)
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,140 @@
+package queue
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"strings"
+
+	"lab-ibnu-sina-queue/internal/database"
+)
+
+// CategoryCount is the number of tickets waiting in a category
+type CategoryCount struct {
+	CategoryID int    `json:"category_id"`
+	Name       string `json:"name"`
+	Prefix     string `json:"prefix"`
+	Waiting    int    `json:"waiting"`
+}
+
+// GetCounterTickets returns today's ticket being called or served at each
+// counter, ordered by counter number
+func GetCounterTickets() ([]Ticket, error) {
+	rows, err := database.DB.Query(`
+		SELECT ` + ticketColumns + `
+		FROM queues
+		WHERE status IN ('calling', 'serving') AND counter_number > 0 AND DATE(created_at) = CURDATE()
+		ORDER BY counter_number, called_at DESC, updated_at DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the latest ticket per counter; older ones were left unfinished
+	tickets := []Ticket{}
+	for _, t := range scanTickets(rows) {
+		if len(tickets) > 0 && tickets[len(tickets)-1].Counter == t.Counter {
+			continue
+		}
+		tickets = append(tickets, t)
+	}
+	return tickets, nil
+}
+
+// CounterIdle is the board status of a counter with no ticket called or
+// being served
+const CounterIdle = "idle"
+
+// BoardEntry is one counter on the "now serving" board. Status is the
+// ticket's status, calling or serving, or CounterIdle without a ticket.
+type BoardEntry struct {
+	Counter int     `json:"counter"`
+	Status  string  `json:"status"`
+	Ticket  *Ticket `json:"ticket"`
+}
+
+func boardEntry(counter int, t *Ticket) BoardEntry {
+	if t == nil {
+		return BoardEntry{Counter: counter, Status: CounterIdle}
+	}
+	return BoardEntry{Counter: counter, Status: t.Status, Ticket: t}
+}
+
+// GetBoardEntry returns the current state of one counter
+func GetBoardEntry(counter int) (BoardEntry, error) {
+	t, err := GetCurrentTicket(counter)
+	if errors.Is(err, sql.ErrNoRows) {
+		return boardEntry(counter, nil), nil
+	}
+	if err != nil {
+		return BoardEntry{}, err
+	}
+	return boardEntry(counter, &t), nil
+}
+
+// GetBoard returns the state of every open counter, ordered by number.
+// Open counters are the given ones plus any with a ticket today.
+func GetBoard(open []int) ([]BoardEntry, error) {
+	tickets, err := GetCounterTickets()
+	if err != nil {
+		return nil, err
+	}
+
+	current := make(map[int]*Ticket)
+	for i := range tickets {
+		current[tickets[i].Counter] = &tickets[i]
+	}
+	counters := make(map[int]bool)
+	for _, n := range open {
+		counters[n] = true
+	}
+	for n := range current {
+		counters[n] = true
+	}
+
+	board := make([]BoardEntry, 0, len(counters))
+	for n := range counters {
+		board = append(board, boardEntry(n, current[n]))
+	}
+	sort.Slice(board, func(i, j int) bool { return board[i].Counter < board[j].Counter })
+	return board, nil
+}
+
+// GetWaitingCounts returns how many tickets passing the filter wait today
+// in every category the filter allows
+func GetWaitingCounts(filter TicketFilter) ([]CategoryCount, error) {
+	where, args := filter.where()
+	categories := ""
+	if len(filter.CategoryIDs) > 0 {
+		categories = " AND c.id IN (?" + strings.Repeat(", ?", len(filter.CategoryIDs)-1) + ")"
+		for _, id := range filter.CategoryIDs {
+			args = append(args, id)
+		}
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT c.id, COALESCE(c.name, ''), COALESCE(c.prefix, ''), COUNT(q.id)
+		FROM categories c
+		LEFT JOIN (
+			SELECT id, category_id FROM queues
+			WHERE status = 'waiting' AND DATE(created_at) = CURDATE()`+where+`
+		) q ON q.category_id = c.id
+		WHERE TRUE`+categories+`
+		GROUP BY c.id, c.name, c.prefix
+		ORDER BY c.id
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []CategoryCount{}
+	for rows.Next() {
+		var c CategoryCount
+		if err := rows.Scan(&c.CategoryID, &c.Name, &c.Prefix, &c.Waiting); err != nil {
+			continue
+		}
+		counts = append(counts, c)
+	}
+	return counts, nil
+}
@@ -0,0 +1,78 @@
+package queue
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"lab-ibnu-sina-queue/internal/database"
+)
+
+var (
+	ErrInvalidEmergency = errors.New("invalid emergency")
+	ErrNoEmergency      = errors.New("no emergency is active")
+)
+
+// Emergency is the override shown full screen on every display and kiosk,
+// e.g. during a fire drill. New tickets cannot be taken while it is active.
+type Emergency struct {
+	Active    bool       `json:"active"`
+	Message   string     `json:"message"`
+	StartedBy string     `json:"started_by,omitempty"`
+	StartedAt *time.Time `json:"started_at,omitempty"`
+}
+
+// GetEmergency returns the current emergency state. It is read from the
+// database every time so all server instances agree.
+func GetEmergency() (Emergency, error) {
+	var e Emergency
+	var startedAt sql.NullTime
+	err := database.DB.QueryRow(`
+		SELECT active, message, started_by, started_at FROM emergency_state WHERE id = 1
+	`).Scan(&e.Active, &e.Message, &e.StartedBy, &startedAt)
+	e.StartedAt = nullTime(startedAt)
+	return e, err
+}
+
+// StartEmergency activates the override with a message. Calling it again
+// while active only changes the message.
+func StartEmergency(message, startedBy string) (Emergency, error) {
+	switch {
+	case message == "":
+		return Emergency{}, fmt.Errorf("%w: message is required", ErrInvalidEmergency)
+	case len(message) > 500:
+		return Emergency{}, fmt.Errorf("%w: message is longer than 500 characters", ErrInvalidEmergency)
+	}
+
+	// MySQL assigns left to right, so the IFs still see the old active
+	_, err := database.DB.Exec(`
+		UPDATE emergency_state SET
+			message = ?,
+			started_by = IF(active, started_by, ?),
+			started_at = IF(active, started_at, NOW()),
+			active = TRUE
+		WHERE id = 1
+	`, message, startedBy)
+	if err != nil {
+		return Emergency{}, err
+	}
+	return GetEmergency()
+}
+
+// ClearEmergency ends the override, returning the state it ended
+func ClearEmergency() (Emergency, error) {
+	e, err := GetEmergency()
+	if err != nil {
+		return Emergency{}, err
+	}
+
+	res, err := database.DB.Exec(`UPDATE emergency_state SET active = FALSE WHERE id = 1 AND active = TRUE`)
+	if err != nil {
+		return Emergency{}, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return Emergency{}, ErrNoEmergency
+	}
+	return e, nil
+}
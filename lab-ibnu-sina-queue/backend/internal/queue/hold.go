@@ -0,0 +1,89 @@
+package queue
+
+import (
+	"lab-ibnu-sina-queue/internal/database"
+	"time"
+)
+
+// HoldTicket parks a waiting or calling ticket so it is not called until it
+// is released. A nil until keeps the ticket held until released manually.
+func HoldTicket(ticketID int, reason string, until *time.Time) (Ticket, error) {
+	res, err := database.DB.Exec(`
+		UPDATE queues SET status = 'held', hold_reason = ?, hold_until = ?
+		WHERE id = ? AND status IN ('waiting', 'calling')
+	`, reason, until, ticketID)
+	if err != nil {
+		return Ticket{}, err
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return Ticket{}, ErrInvalidTransition
+	}
+
+	return GetTicket(ticketID)
+}
+
+// ReleaseTicket returns a held ticket to the waiting list. The ticket keeps
+// its original position since waiting tickets are ordered by ID.
+func ReleaseTicket(ticketID int) (Ticket, error) {
+	res, err := database.DB.Exec(`
+		UPDATE queues SET status = 'waiting', counter_number = 0, hold_reason = NULL, hold_until = NULL
+		WHERE id = ? AND status = 'held'
+	`, ticketID)
+	if err != nil {
+		return Ticket{}, err
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return Ticket{}, ErrInvalidTransition
+	}
+
+	return GetTicket(ticketID)
+}
+
+// GetHeldTickets returns today's held tickets
+func GetHeldTickets() ([]Ticket, error) {
+	rows, err := database.DB.Query(`
+		SELECT ` + ticketColumns + `
+		FROM queues
+		WHERE status = 'held' AND DATE(created_at) = CURDATE()
+		ORDER BY category_id, id ASC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	return scanTickets(rows), nil
+}
+
+// ReleaseExpiredHolds moves every held ticket whose reactivation time has
+// passed back to waiting and returns the released tickets
+func ReleaseExpiredHolds(now time.Time) ([]Ticket, error) {
+	rows, err := database.DB.Query(`
+		SELECT id FROM queues
+		WHERE status = 'held' AND hold_until IS NOT NULL AND hold_until <= ?
+	`, now)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	var released []Ticket
+	for _, id := range ids {
+		t, err := ReleaseTicket(id)
+		if err != nil {
+			// Released manually in the meantime
+			continue
+		}
+		released = append(released, t)
+	}
+	return released, nil
+}
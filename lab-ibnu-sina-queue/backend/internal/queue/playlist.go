@@ -0,0 +1,249 @@
+package queue
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"lab-ibnu-sina-queue/internal/database"
+)
+
+var ErrInvalidPlaylistItem = errors.New("invalid playlist item")
+
+// Kinds of playlist items
+const (
+	PlaylistVideo = "video"
+	PlaylistImage = "image"
+	PlaylistText  = "text"
+)
+
+// PlaylistItem is one piece of content shown on the displays' media area.
+// Items play in position order, each for Duration seconds (videos with a
+// zero duration play to the end). StartsOn/EndsOn limit the dates, and
+// DailyStart/DailyEnd and Weekdays the times, an item is shown; empty
+// values mean no limit. Displays evaluate the schedule with their own clock.
+type PlaylistItem struct {
+	ID         int    `json:"id"`
+	Kind       string `json:"kind"`
+	Title      string `json:"title"`
+	Body       string `json:"body"`
+	MediaURL   string `json:"media_url"`
+	Duration   int    `json:"duration"`
+	Position   int    `json:"position"`
+	StartsOn   string `json:"starts_on,omitempty"`
+	EndsOn     string `json:"ends_on,omitempty"`
+	DailyStart string `json:"daily_start,omitempty"`
+	DailyEnd   string `json:"daily_end,omitempty"`
+	Weekdays   []int  `json:"weekdays"`
+	Enabled    bool   `json:"enabled"`
+}
+
+const playlistColumns = `id, kind, title, body, media_url, duration_seconds, position,
+	COALESCE(DATE_FORMAT(starts_on, '%Y-%m-%d'), ''), COALESCE(DATE_FORMAT(ends_on, '%Y-%m-%d'), ''),
+	COALESCE(TIME_FORMAT(daily_start, '%H:%i'), ''), COALESCE(TIME_FORMAT(daily_end, '%H:%i'), ''),
+	weekdays, enabled`
+
+func scanPlaylistItem(row interface{ Scan(...interface{}) error }) (PlaylistItem, error) {
+	var item PlaylistItem
+	var weekdays string
+	err := row.Scan(&item.ID, &item.Kind, &item.Title, &item.Body, &item.MediaURL, &item.Duration, &item.Position,
+		&item.StartsOn, &item.EndsOn, &item.DailyStart, &item.DailyEnd, &weekdays, &item.Enabled)
+	item.Weekdays = parseIntList(weekdays)
+	return item, err
+}
+
+// parseIntList reads a stored comma separated list such as the ISO
+// weekdays (1 = Monday) of a playlist item
+func parseIntList(value string) []int {
+	list := []int{}
+	for _, part := range strings.Split(value, ",") {
+		if n, err := strconv.Atoi(part); err == nil {
+			list = append(list, n)
+		}
+	}
+	return list
+}
+
+func formatIntList(list []int) string {
+	parts := make([]string, len(list))
+	for i, n := range list {
+		parts[i] = strconv.Itoa(n)
+	}
+	return strings.Join(parts, ",")
+}
+
+// nullString stores empty schedule values as NULL
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// Validate checks an item before it is stored
+func (item PlaylistItem) Validate() error {
+	invalid := func(reason string) error {
+		return fmt.Errorf("%w: %s", ErrInvalidPlaylistItem, reason)
+	}
+
+	switch item.Kind {
+	case PlaylistVideo, PlaylistImage:
+		if item.MediaURL == "" {
+			return invalid("media_url is required for " + item.Kind + " items")
+		}
+	case PlaylistText:
+		if item.Title == "" && item.Body == "" {
+			return invalid("text items need a title or body")
+		}
+	default:
+		return invalid("kind must be video, image or text")
+	}
+
+	if item.Duration < 0 || item.Duration > 3600 {
+		return invalid("duration must be between 0 and 3600 seconds")
+	}
+	if item.Duration == 0 && item.Kind != PlaylistVideo {
+		return invalid("image and text items need a duration")
+	}
+
+	for _, date := range []string{item.StartsOn, item.EndsOn} {
+		if _, err := time.Parse("2006-01-02", date); date != "" && err != nil {
+			return invalid("dates must look like 2006-01-02")
+		}
+	}
+	if item.StartsOn != "" && item.EndsOn != "" && item.EndsOn < item.StartsOn {
+		return invalid("ends_on is before starts_on")
+	}
+
+	for _, clock := range []string{item.DailyStart, item.DailyEnd} {
+		if _, err := time.Parse("15:04", clock); clock != "" && err != nil {
+			return invalid("daily times must look like 15:04")
+		}
+	}
+	if (item.DailyStart == "") != (item.DailyEnd == "") {
+		return invalid("daily_start and daily_end go together")
+	}
+
+	for _, day := range item.Weekdays {
+		if day < 1 || day > 7 {
+			return invalid("weekdays run from 1 (Monday) to 7 (Sunday)")
+		}
+	}
+	return nil
+}
+
+// GetPlaylist returns every playlist item in play order
+func GetPlaylist() ([]PlaylistItem, error) {
+	rows, err := database.DB.Query(`SELECT ` + playlistColumns + ` FROM playlist_items ORDER BY position, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []PlaylistItem{}
+	for rows.Next() {
+		item, err := scanPlaylistItem(rows)
+		if err != nil {
+			continue
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// GetPlaylistItem finds a playlist item by ID
+func GetPlaylistItem(id int) (PlaylistItem, error) {
+	return scanPlaylistItem(database.DB.QueryRow(`SELECT `+playlistColumns+` FROM playlist_items WHERE id = ?`, id))
+}
+
+// CreatePlaylistItem adds an item at the end of the playlist
+func CreatePlaylistItem(item PlaylistItem) (PlaylistItem, error) {
+	if err := item.Validate(); err != nil {
+		return PlaylistItem{}, err
+	}
+
+	res, err := database.DB.Exec(`
+		INSERT INTO playlist_items (kind, title, body, media_url, duration_seconds, position,
+			starts_on, ends_on, daily_start, daily_end, weekdays, enabled)
+		SELECT ?, ?, ?, ?, ?, COALESCE(MAX(position), 0) + 1, ?, ?, ?, ?, ?, ?
+		FROM playlist_items
+	`, item.Kind, item.Title, item.Body, item.MediaURL, item.Duration,
+		nullString(item.StartsOn), nullString(item.EndsOn), nullString(item.DailyStart), nullString(item.DailyEnd),
+		formatIntList(item.Weekdays), item.Enabled)
+	if err != nil {
+		return PlaylistItem{}, err
+	}
+
+	id, _ := res.LastInsertId()
+	return GetPlaylistItem(int(id))
+}
+
+// UpdatePlaylistItem replaces the content and schedule of an item; its
+// position is changed with ReorderPlaylist
+func UpdatePlaylistItem(item PlaylistItem) (PlaylistItem, error) {
+	if err := item.Validate(); err != nil {
+		return PlaylistItem{}, err
+	}
+
+	res, err := database.DB.Exec(`
+		UPDATE playlist_items SET kind = ?, title = ?, body = ?, media_url = ?, duration_seconds = ?,
+			starts_on = ?, ends_on = ?, daily_start = ?, daily_end = ?, weekdays = ?, enabled = ?
+		WHERE id = ?
+	`, item.Kind, item.Title, item.Body, item.MediaURL, item.Duration,
+		nullString(item.StartsOn), nullString(item.EndsOn), nullString(item.DailyStart), nullString(item.DailyEnd),
+		formatIntList(item.Weekdays), item.Enabled, item.ID)
+	if err != nil {
+		return PlaylistItem{}, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// MySQL reports 0 rows for unchanged values, so check it exists
+		if _, err := GetPlaylistItem(item.ID); err != nil {
+			return PlaylistItem{}, err
+		}
+	}
+
+	return GetPlaylistItem(item.ID)
+}
+
+// DeletePlaylistItem removes an item from the playlist
+func DeletePlaylistItem(id int) error {
+	res, err := database.DB.Exec(`DELETE FROM playlist_items WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// MediaInUse reports whether the display settings or a playlist item show
+// the given URL
+func MediaInUse(url string) (bool, error) {
+	var count int
+	err := database.DB.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM display_settings WHERE video_url = ?)
+			+ (SELECT COUNT(*) FROM playlist_items WHERE media_url = ?)
+	`, url, url).Scan(&count)
+	return count > 0, err
+}
+
+// ReorderPlaylist sets the play order to the given item IDs. Items not
+// listed keep their relative order after the listed ones.
+func ReorderPlaylist(ids []int) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE playlist_items SET position = position + ?`, len(ids)); err != nil {
+		return err
+	}
+	for i, id := range ids {
+		if _, err := tx.Exec(`UPDATE playlist_items SET position = ? WHERE id = ?`, i+1, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,42 @@
+package queue
+
+import (
+	"database/sql"
+	"time"
+
+	"lab-ibnu-sina-queue/internal/database"
+)
+
+// ReserveCallSlots books the start times of a spoken call played repeats
+// times, each taking length and followed by spacing of silence. Calls get
+// consecutive slots in the order they are reserved, the first no earlier
+// than now. The schedule lives in the database so that calls made through
+// different server instances do not overlap either.
+func ReserveCallSlots(now time.Time, length, spacing time.Duration, repeats int) ([]time.Time, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var nextAt sql.NullTime
+	if err := tx.QueryRow(`SELECT next_at FROM call_schedule WHERE id = 1 FOR UPDATE`).Scan(&nextAt); err != nil {
+		return nil, err
+	}
+
+	start := now
+	if nextAt.Valid && nextAt.Time.After(now) {
+		start = nextAt.Time
+	}
+
+	slots := make([]time.Time, repeats)
+	for i := range slots {
+		slots[i] = start
+		start = start.Add(length + spacing)
+	}
+
+	if _, err := tx.Exec(`UPDATE call_schedule SET next_at = ? WHERE id = 1`, start); err != nil {
+		return nil, err
+	}
+	return slots, tx.Commit()
+}
@@ -1,18 +1,81 @@
 package queue
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	"lab-ibnu-sina-queue/internal/database"
 	"time"
 )
 
 type Ticket struct {
-	ID            int       `json:"id"`
-	CategoryID    int       `json:"category_id"`
-	FormattedCode string    `json:"formatted_code"`
-	Status        string    `json:"status"`
-	Counter       int       `json:"counter"`
-	CreatedAt     time.Time `json:"created_at"`
+	ID            int        `json:"id"`
+	CategoryID    int        `json:"category_id"`
+	FormattedCode string     `json:"formatted_code"`
+	Status        string     `json:"status"`
+	Counter       int        `json:"counter"`
+	CreatedAt     time.Time  `json:"created_at"`
+	HoldReason    string     `json:"hold_reason,omitempty"`
+	HoldUntil     *time.Time `json:"hold_until,omitempty"`
+
+	// Lifecycle timestamps, set as the ticket moves through the counters
+	FirstCalledAt    *time.Time `json:"first_called_at,omitempty"`
+	CalledAt         *time.Time `json:"called_at,omitempty"`
+	ServingStartedAt *time.Time `json:"serving_started_at,omitempty"`
+	FinishedAt       *time.Time `json:"finished_at,omitempty"`
+	CallCount        int        `json:"call_count"`
+	RecallCount      int        `json:"recall_count"`
+}
+
+// ErrInvalidTransition is returned when a ticket is not in a status that
+// allows the requested change (e.g. holding a finished ticket)
+var ErrInvalidTransition = errors.New("ticket status does not allow this action")
+
+// ticketColumns is the column list understood by scanTicket
+const ticketColumns = `id, category_id, formatted_code, status, counter_number, created_at,
+		COALESCE(hold_reason, ''), hold_until,
+		first_called_at, called_at, serving_started_at, finished_at, call_count, recall_count`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanTicket reads a row selected with ticketColumns into a Ticket
+func scanTicket(row scanner) (Ticket, error) {
+	var t Ticket
+	var holdUntil, firstCalledAt, calledAt, servingStartedAt, finishedAt sql.NullTime
+	err := row.Scan(&t.ID, &t.CategoryID, &t.FormattedCode, &t.Status, &t.Counter, &t.CreatedAt,
+		&t.HoldReason, &holdUntil,
+		&firstCalledAt, &calledAt, &servingStartedAt, &finishedAt, &t.CallCount, &t.RecallCount)
+
+	t.HoldUntil = nullTime(holdUntil)
+	t.FirstCalledAt = nullTime(firstCalledAt)
+	t.CalledAt = nullTime(calledAt)
+	t.ServingStartedAt = nullTime(servingStartedAt)
+	t.FinishedAt = nullTime(finishedAt)
+	return t, err
+}
+
+func nullTime(nt sql.NullTime) *time.Time {
+	if !nt.Valid {
+		return nil
+	}
+	return &nt.Time
+}
+
+// scanTickets collects all rows selected with ticketColumns
+func scanTickets(rows *sql.Rows) []Ticket {
+	defer rows.Close()
+
+	var tickets []Ticket
+	for rows.Next() {
+		t, err := scanTicket(rows)
+		if err != nil {
+			continue
+		}
+		tickets = append(tickets, t)
+	}
+	return tickets
 }
 
 type DisplaySettings struct {
@@ -21,8 +84,15 @@ type DisplaySettings struct {
 	Subtitle string `json:"subtitle"`
 }
 
+// TicketSource records where a ticket was requested from, so bursts from
+// one kiosk or address can be traced and voided
+type TicketSource struct {
+	DeviceID int
+	IP       string
+}
+
 // GenerateTicket creates a new ticket in the DB
-func GenerateTicket(categoryID int) (Ticket, error) {
+func GenerateTicket(categoryID int, source TicketSource) (Ticket, error) {
 	// 1. Get current max number for today for this category
 	var lastNum int
 	err := database.DB.QueryRow(`
@@ -48,9 +118,9 @@ func GenerateTicket(categoryID int) (Ticket, error) {
 
 	// Insert
 	res, err := database.DB.Exec(`
-		INSERT INTO queues (category_id, ticket_number, formatted_code, status) 
-		VALUES (?, ?, ?, 'waiting')
-	`, categoryID, newNum, formatted)
+		INSERT INTO queues (category_id, ticket_number, formatted_code, status, source_device_id, source_ip) 
+		VALUES (?, ?, ?, 'waiting', ?, ?)
+	`, categoryID, newNum, formatted, source.DeviceID, source.IP)
 
 	if err != nil {
 		return Ticket{}, err
@@ -77,44 +147,42 @@ func UpdateStatus(ticketID int, status string, counter int) error {
 
 // GetNextWaiting gets the next ticket to call for a category
 func GetNextWaiting(categoryID int) (Ticket, error) {
-	var t Ticket
-	err := database.DB.QueryRow(`
-		SELECT id, category_id, formatted_code, status, counter_number, created_at 
+	return scanTicket(database.DB.QueryRow(`
+		SELECT `+ticketColumns+`
 		FROM queues 
 		WHERE category_id = ? AND status = 'waiting'
 		ORDER BY id ASC LIMIT 1
-	`, categoryID).Scan(&t.ID, &t.CategoryID, &t.FormattedCode, &t.Status, &t.Counter, &t.CreatedAt)
+	`, categoryID))
+}
 
-	return t, err
+// GetTicket finds a ticket by its ID
+func GetTicket(ticketID int) (Ticket, error) {
+	return scanTicket(database.DB.QueryRow(`
+		SELECT `+ticketColumns+`
+		FROM queues WHERE id = ?
+	`, ticketID))
 }
 
-// GetRecentTickets returns the last 5 active/waiting tickets
-func GetRecentTickets() ([]Ticket, error) {
+// GetRecentTickets returns the last 5 active/waiting tickets passing the
+// filter
+func GetRecentTickets(filter TicketFilter) ([]Ticket, error) {
+	where, args := filter.where()
 	rows, err := database.DB.Query(`
-		SELECT id, category_id, formatted_code, status, counter_number, created_at
+		SELECT `+ticketColumns+`
 		FROM queues
+		WHERE status <> 'voided'`+where+`
 		ORDER BY id DESC LIMIT 5
-	`)
+	`, args...)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	var tickets []Ticket
-	for rows.Next() {
-		var t Ticket
-		if err := rows.Scan(&t.ID, &t.CategoryID, &t.FormattedCode, &t.Status, &t.Counter, &t.CreatedAt); err != nil {
-			continue
-		}
-		tickets = append(tickets, t)
-	}
-	return tickets, nil
+	return scanTickets(rows), nil
 }
 
 // GetWaitingTickets returns all waiting tickets grouped by category
 func GetWaitingTickets() ([]Ticket, error) {
 	rows, err := database.DB.Query(`
-		SELECT id, category_id, formatted_code, status, counter_number, created_at
+		SELECT ` + ticketColumns + `
 		FROM queues
 		WHERE status = 'waiting' AND DATE(created_at) = CURDATE()
 		ORDER BY category_id, id ASC
@@ -122,47 +190,119 @@ func GetWaitingTickets() ([]Ticket, error) {
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	var tickets []Ticket
-	for rows.Next() {
-		var t Ticket
-		if err := rows.Scan(&t.ID, &t.CategoryID, &t.FormattedCode, &t.Status, &t.Counter, &t.CreatedAt); err != nil {
-			continue
-		}
-		tickets = append(tickets, t)
-	}
-	return tickets, nil
+	return scanTickets(rows), nil
 }
 
-// CallTicket marks a ticket as 'calling' and assigns counter
+// CallTicket marks a ticket as 'calling' and assigns counter. Waiting,
+// skipped and already called tickets can be called; held, voided, served
+// and finished ones cannot.
 func CallTicket(ticketID int, counter int) (Ticket, error) {
-	_, err := database.DB.Exec(`
-		UPDATE queues SET status = 'calling', counter_number = ? WHERE id = ?
+	res, err := database.DB.Exec(`
+		UPDATE queues SET status = 'calling', counter_number = ?,
+			called_at = NOW(), first_called_at = COALESCE(first_called_at, NOW()),
+			call_count = call_count + 1
+		WHERE id = ? AND status IN ('waiting', 'calling', 'skipped')
 	`, counter, ticketID)
 	if err != nil {
 		return Ticket{}, err
 	}
 
-	var t Ticket
-	err = database.DB.QueryRow(`
-		SELECT id, category_id, formatted_code, status, counter_number, created_at
-		FROM queues WHERE id = ?
-	`, ticketID).Scan(&t.ID, &t.CategoryID, &t.FormattedCode, &t.Status, &t.Counter, &t.CreatedAt)
+	if err := checkTransition(res, ticketID); err != nil {
+		return Ticket{}, err
+	}
 
-	return t, err
+	return GetTicket(ticketID)
+}
+
+// CallNextTicket calls the oldest ticket waiting today to a counter, limited
+// to a category when categoryID is not 0. The ticket is claimed in a single
+// update so two counters never get the same one. Returns sql.ErrNoRows when
+// nobody is waiting.
+func CallNextTicket(counter int, categoryID int) (Ticket, error) {
+	// LAST_INSERT_ID(id) makes the driver report the ID of the claimed row
+	res, err := database.DB.Exec(`
+		UPDATE queues SET id = LAST_INSERT_ID(id), status = 'calling', counter_number = ?,
+			called_at = NOW(), first_called_at = COALESCE(first_called_at, NOW()),
+			call_count = call_count + 1
+		WHERE status = 'waiting' AND DATE(created_at) = CURDATE() AND (? = 0 OR category_id = ?)
+		ORDER BY id ASC LIMIT 1
+	`, counter, categoryID, categoryID)
+	if err != nil {
+		return Ticket{}, err
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return Ticket{}, sql.ErrNoRows
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Ticket{}, err
+	}
+	return GetTicket(int(id))
+}
+
+// RecordRecall counts a repeated announcement of an already called ticket
+func RecordRecall(ticketID int) (Ticket, error) {
+	_, err := database.DB.Exec(`UPDATE queues SET recall_count = recall_count + 1 WHERE id = ?`, ticketID)
+	if err != nil {
+		return Ticket{}, err
+	}
+
+	return GetTicket(ticketID)
+}
+
+// ServeTicket marks a called ticket as being served at its counter
+func ServeTicket(ticketID int) (Ticket, error) {
+	res, err := database.DB.Exec(`
+		UPDATE queues SET status = 'serving', serving_started_at = NOW()
+		WHERE id = ? AND status = 'calling'
+	`, ticketID)
+	if err != nil {
+		return Ticket{}, err
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return Ticket{}, ErrInvalidTransition
+	}
+
+	return GetTicket(ticketID)
 }
 
-// FinishTicket marks ticket as finished
+// FinishTicket marks a called or served ticket as finished
 func FinishTicket(ticketID int) error {
-	_, err := database.DB.Exec(`UPDATE queues SET status = 'finished' WHERE id = ?`, ticketID)
-	return err
+	res, err := database.DB.Exec(`
+		UPDATE queues SET status = 'finished', finished_at = NOW()
+		WHERE id = ? AND status IN ('calling', 'serving')
+	`, ticketID)
+	if err != nil {
+		return err
+	}
+	return checkTransition(res, ticketID)
 }
 
-// SkipTicket marks ticket as skipped
+// SkipTicket marks a waiting or called ticket as skipped
 func SkipTicket(ticketID int) error {
-	_, err := database.DB.Exec(`UPDATE queues SET status = 'skipped' WHERE id = ?`, ticketID)
-	return err
+	res, err := database.DB.Exec(`
+		UPDATE queues SET status = 'skipped'
+		WHERE id = ? AND status IN ('waiting', 'calling')
+	`, ticketID)
+	if err != nil {
+		return err
+	}
+	return checkTransition(res, ticketID)
+}
+
+// checkTransition tells an unknown ticket (sql.ErrNoRows) from one whose
+// status did not allow the update (ErrInvalidTransition)
+func checkTransition(res sql.Result, ticketID int) error {
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil
+	}
+	if _, err := GetTicket(ticketID); err != nil {
+		return err
+	}
+	return ErrInvalidTransition
 }
 
 // ResetDailyQueue resets all today's queues
@@ -184,6 +324,14 @@ func GetQueueStats() (map[string]int, error) {
 	database.DB.QueryRow(`SELECT COUNT(*) FROM queues WHERE status = 'calling' AND DATE(created_at) = CURDATE()`).Scan(&count)
 	stats["calling"] = count
 
+	// Total serving
+	database.DB.QueryRow(`SELECT COUNT(*) FROM queues WHERE status = 'serving' AND DATE(created_at) = CURDATE()`).Scan(&count)
+	stats["serving"] = count
+
+	// Total held
+	database.DB.QueryRow(`SELECT COUNT(*) FROM queues WHERE status = 'held' AND DATE(created_at) = CURDATE()`).Scan(&count)
+	stats["held"] = count
+
 	// Total finished
 	database.DB.QueryRow(`SELECT COUNT(*) FROM queues WHERE status = 'finished' AND DATE(created_at) = CURDATE()`).Scan(&count)
 	stats["finished"] = count
@@ -192,34 +340,61 @@ func GetQueueStats() (map[string]int, error) {
 	database.DB.QueryRow(`SELECT COUNT(*) FROM queues WHERE status = 'skipped' AND DATE(created_at) = CURDATE()`).Scan(&count)
 	stats["skipped"] = count
 
+	// Total voided
+	database.DB.QueryRow(`SELECT COUNT(*) FROM queues WHERE status = 'voided' AND DATE(created_at) = CURDATE()`).Scan(&count)
+	stats["voided"] = count
+
 	// Total today
-	database.DB.QueryRow(`SELECT COUNT(*) FROM queues WHERE DATE(created_at) = CURDATE()`).Scan(&count)
+	database.DB.QueryRow(`SELECT COUNT(*) FROM queues WHERE status <> 'voided' AND DATE(created_at) = CURDATE()`).Scan(&count)
 	stats["total"] = count
 
+	// Average durations in seconds
+	if report, err := GetTimingReport(time.Now()); err == nil {
+		stats["avg_wait_seconds"] = report.Overall.AvgWaitSeconds
+		stats["avg_service_seconds"] = report.Overall.AvgServiceSeconds
+	}
+
 	return stats, nil
 }
 
 // GetCurrentCalling returns the currently calling ticket for a counter
 func GetCurrentCalling(counter int) (Ticket, error) {
-	var t Ticket
-	err := database.DB.QueryRow(`
-		SELECT id, category_id, formatted_code, status, counter_number, created_at
+	return currentAtCounter(counter, `status = 'calling'`)
+}
+
+// GetCurrentTicket returns the ticket being called or served at a counter
+func GetCurrentTicket(counter int) (Ticket, error) {
+	return currentAtCounter(counter, `status IN ('calling', 'serving')`)
+}
+
+// currentAtCounter returns today's latest ticket at a counter matching the
+// status condition
+func currentAtCounter(counter int, statusCondition string) (Ticket, error) {
+	return scanTicket(database.DB.QueryRow(`
+		SELECT `+ticketColumns+`
 		FROM queues 
-		WHERE status = 'calling' AND counter_number = ?
-		ORDER BY updated_at DESC LIMIT 1
-	`, counter).Scan(&t.ID, &t.CategoryID, &t.FormattedCode, &t.Status, &t.Counter, &t.CreatedAt)
-	return t, err
+		WHERE `+statusCondition+` AND counter_number = ? AND DATE(created_at) = CURDATE()
+		ORDER BY called_at DESC, updated_at DESC LIMIT 1
+	`, counter))
+}
+
+// RecallTicket re-announces the ticket currently called to a counter and
+// counts the recall. Returns sql.ErrNoRows when nothing is being called.
+func RecallTicket(counter int) (Ticket, error) {
+	t, err := GetCurrentCalling(counter)
+	if err != nil {
+		return Ticket{}, err
+	}
+	return RecordRecall(t.ID)
 }
 
 // GetTicketByCode finds a ticket by its code (e.g. "A-005") for today
 func GetTicketByCode(code string) (Ticket, error) {
-	var t Ticket
-	err := database.DB.QueryRow(`
-		SELECT id, category_id, formatted_code, status, counter_number, created_at
+	return scanTicket(database.DB.QueryRow(`
+		SELECT `+ticketColumns+`
 		FROM queues
 		WHERE formatted_code = ? AND DATE(created_at) = CURDATE()
-	`, code).Scan(&t.ID, &t.CategoryID, &t.FormattedCode, &t.Status, &t.Counter, &t.CreatedAt)
-	return t, err
+	`, code))
 }
 
 // GetDisplaySettings retrieves the current video/text settings
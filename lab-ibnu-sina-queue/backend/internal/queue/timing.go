@@ -0,0 +1,172 @@
+package queue
+
+import (
+	"database/sql"
+	"lab-ibnu-sina-queue/internal/database"
+	"sort"
+	"time"
+)
+
+// TimingSummary holds waiting and service durations in seconds.
+// Wait is measured from ticket creation to its first call, service from the
+// start of service (or the last call if serving was never marked) to finish.
+type TimingSummary struct {
+	Tickets           int `json:"tickets"`
+	Served            int `json:"served"`
+	AvgWaitSeconds    int `json:"avg_wait_seconds"`
+	P50WaitSeconds    int `json:"p50_wait_seconds"`
+	P90WaitSeconds    int `json:"p90_wait_seconds"`
+	AvgServiceSeconds int `json:"avg_service_seconds"`
+	P50ServiceSeconds int `json:"p50_service_seconds"`
+	P90ServiceSeconds int `json:"p90_service_seconds"`
+}
+
+type CategoryTiming struct {
+	CategoryID int `json:"category_id"`
+	TimingSummary
+}
+
+type CounterTiming struct {
+	Counter int `json:"counter"`
+	TimingSummary
+}
+
+// TimingReport is the timing breakdown of a single day
+type TimingReport struct {
+	Date       string           `json:"date"`
+	Overall    TimingSummary    `json:"overall"`
+	Categories []CategoryTiming `json:"categories"`
+	Counters   []CounterTiming  `json:"counters"`
+}
+
+// durations collects raw samples before they are summarized
+type durations struct {
+	tickets int
+	wait    []float64
+	service []float64
+}
+
+func (d *durations) summary() TimingSummary {
+	avgWait, p50Wait, p90Wait := describe(d.wait)
+	avgService, p50Service, p90Service := describe(d.service)
+	return TimingSummary{
+		Tickets:           d.tickets,
+		Served:            len(d.service),
+		AvgWaitSeconds:    avgWait,
+		P50WaitSeconds:    p50Wait,
+		P90WaitSeconds:    p90Wait,
+		AvgServiceSeconds: avgService,
+		P50ServiceSeconds: p50Service,
+		P90ServiceSeconds: p90Service,
+	}
+}
+
+// describe returns the mean, median and 90th percentile of the samples
+func describe(samples []float64) (avg, p50, p90 int) {
+	if len(samples) == 0 {
+		return 0, 0, 0
+	}
+
+	sort.Float64s(samples)
+	var sum float64
+	for _, s := range samples {
+		sum += s
+	}
+	return int(sum / float64(len(samples))), percentile(samples, 50), percentile(samples, 90)
+}
+
+// percentile uses the nearest-rank method on sorted samples
+func percentile(sorted []float64, p int) int {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return int(sorted[rank-1])
+}
+
+// GetTimingReport computes wait and service durations for the tickets
+// created on the given day, overall and per category and counter. Voided
+// tickets were never really waiting and are left out.
+func GetTimingReport(day time.Time) (TimingReport, error) {
+	date := day.Format("2006-01-02")
+	report := TimingReport{Date: date}
+
+	rows, err := database.DB.Query(`
+		SELECT category_id, counter_number, created_at,
+			first_called_at, called_at, serving_started_at, finished_at
+		FROM queues
+		WHERE DATE(created_at) = ? AND status <> 'voided'
+	`, date)
+	if err != nil {
+		return report, err
+	}
+	defer rows.Close()
+
+	overall := &durations{}
+	categories := make(map[int]*durations)
+	counters := make(map[int]*durations)
+
+	for rows.Next() {
+		var categoryID, counter int
+		var createdAt time.Time
+		var firstCalledAt, calledAt, servingStartedAt, finishedAt sql.NullTime
+		if err := rows.Scan(&categoryID, &counter, &createdAt,
+			&firstCalledAt, &calledAt, &servingStartedAt, &finishedAt); err != nil {
+			continue
+		}
+
+		groups := []*durations{overall, group(categories, categoryID)}
+		if counter > 0 {
+			groups = append(groups, group(counters, counter))
+		}
+
+		for _, g := range groups {
+			g.tickets++
+			if firstCalledAt.Valid {
+				g.wait = append(g.wait, firstCalledAt.Time.Sub(createdAt).Seconds())
+			}
+		}
+
+		serviceStart := servingStartedAt
+		if !serviceStart.Valid {
+			serviceStart = calledAt
+		}
+		if finishedAt.Valid && serviceStart.Valid {
+			for _, g := range groups {
+				g.service = append(g.service, finishedAt.Time.Sub(serviceStart.Time).Seconds())
+			}
+		}
+	}
+
+	report.Overall = overall.summary()
+
+	report.Categories = []CategoryTiming{}
+	for _, id := range sortedKeys(categories) {
+		report.Categories = append(report.Categories, CategoryTiming{CategoryID: id, TimingSummary: categories[id].summary()})
+	}
+
+	report.Counters = []CounterTiming{}
+	for _, n := range sortedKeys(counters) {
+		report.Counters = append(report.Counters, CounterTiming{Counter: n, TimingSummary: counters[n].summary()})
+	}
+
+	return report, nil
+}
+
+func group(groups map[int]*durations, key int) *durations {
+	g, ok := groups[key]
+	if !ok {
+		g = &durations{}
+		groups[key] = g
+	}
+	return g
+}
+
+func sortedKeys(groups map[int]*durations) []int {
+	keys := make([]int, 0, len(groups))
+	for k := range groups {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
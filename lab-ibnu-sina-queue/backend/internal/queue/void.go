@@ -0,0 +1,74 @@
+package queue
+
+import (
+	"errors"
+	"lab-ibnu-sina-queue/internal/database"
+	"strings"
+	"time"
+)
+
+var ErrEmptyVoidFilter = errors.New("ticket IDs, a device or an IP address is required")
+
+// VoidFilter selects waiting tickets to void. Either TicketIDs, or a
+// device/IP within the Since..Until window, must be given.
+type VoidFilter struct {
+	TicketIDs []int
+	DeviceID  int
+	IP        string
+	Since     time.Time
+	Until     time.Time
+}
+
+// VoidTickets marks the waiting tickets matching the filter as voided, e.g.
+// a burst of tickets printed by a child playing with the kiosk
+func VoidTickets(f VoidFilter) ([]Ticket, error) {
+	where := []string{"status = 'waiting'"}
+	var args []interface{}
+
+	switch {
+	case len(f.TicketIDs) > 0:
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(f.TicketIDs)), ", ")
+		where = append(where, "id IN ("+placeholders+")")
+		for _, id := range f.TicketIDs {
+			args = append(args, id)
+		}
+	case f.DeviceID > 0 || f.IP != "":
+		if f.DeviceID > 0 {
+			where = append(where, "source_device_id = ?")
+			args = append(args, f.DeviceID)
+		}
+		if f.IP != "" {
+			where = append(where, "source_ip = ?")
+			args = append(args, f.IP)
+		}
+		where = append(where, "created_at >= ?", "created_at <= ?")
+		args = append(args, f.Since, f.Until)
+	default:
+		return nil, ErrEmptyVoidFilter
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT `+ticketColumns+`
+		FROM queues
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY id ASC
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	tickets := scanTickets(rows)
+
+	voided := []Ticket{}
+	for _, t := range tickets {
+		res, err := database.DB.Exec(`UPDATE queues SET status = 'voided' WHERE id = ? AND status = 'waiting'`, t.ID)
+		if err != nil {
+			return voided, err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+		t.Status = "voided"
+		voided = append(voided, t)
+	}
+	return voided, nil
+}
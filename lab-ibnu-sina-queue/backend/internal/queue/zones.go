@@ -0,0 +1,218 @@
+package queue
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"lab-ibnu-sina-queue/internal/database"
+)
+
+var ErrInvalidZone = errors.New("invalid zone")
+
+// TicketFilter limits tickets to some categories and counters. Empty lists
+// allow all; tickets not at a counter yet pass the counter list.
+type TicketFilter struct {
+	CategoryIDs []int `json:"category_ids"`
+	Counters    []int `json:"counters"`
+}
+
+func containsInt(list []int, n int) bool {
+	for _, v := range list {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
+
+// Narrow limits the filter further to some categories and counters, e.g.
+// the ones a display asks for; nil lists change nothing. ok is false when
+// no ticket can pass, such as for a counter outside the filter.
+func (f TicketFilter) Narrow(categoryIDs, counters []int) (narrowed TicketFilter, ok bool) {
+	narrowed = f
+	if categoryIDs != nil {
+		if narrowed.CategoryIDs = intersect(f.CategoryIDs, categoryIDs); len(narrowed.CategoryIDs) == 0 {
+			return narrowed, false
+		}
+	}
+	if counters != nil {
+		if narrowed.Counters = intersect(f.Counters, counters); len(narrowed.Counters) == 0 {
+			return narrowed, false
+		}
+	}
+	return narrowed, true
+}
+
+// intersect returns the entries of requested that limit allows; an empty
+// limit allows all
+func intersect(limit, requested []int) []int {
+	if len(limit) == 0 {
+		return requested
+	}
+	list := []int{}
+	for _, n := range requested {
+		if containsInt(limit, n) {
+			list = append(list, n)
+		}
+	}
+	return list
+}
+
+// AllowsCounter reports whether the filter includes a counter
+func (f TicketFilter) AllowsCounter(counter int) bool {
+	return len(f.Counters) == 0 || containsInt(f.Counters, counter)
+}
+
+// Allows reports whether a ticket passes the filter
+func (f TicketFilter) Allows(t Ticket) bool {
+	if len(f.CategoryIDs) > 0 && !containsInt(f.CategoryIDs, t.CategoryID) {
+		return false
+	}
+	if len(f.Counters) > 0 && t.Counter > 0 && !containsInt(f.Counters, t.Counter) {
+		return false
+	}
+	return true
+}
+
+// where returns the SQL conditions of the filter, starting with AND
+func (f TicketFilter) where() (string, []interface{}) {
+	var sb strings.Builder
+	var args []interface{}
+	if len(f.CategoryIDs) > 0 {
+		sb.WriteString(" AND category_id IN (?" + strings.Repeat(", ?", len(f.CategoryIDs)-1) + ")")
+		for _, id := range f.CategoryIDs {
+			args = append(args, id)
+		}
+	}
+	if len(f.Counters) > 0 {
+		sb.WriteString(" AND (counter_number = 0 OR counter_number IN (?" + strings.Repeat(", ?", len(f.Counters)-1) + "))")
+		for _, n := range f.Counters {
+			args = append(args, n)
+		}
+	}
+	return sb.String(), args
+}
+
+// Zone is a display profile, e.g. the sampling area showing counters 1
+// and 2 or result collection showing one category. Displays are assigned
+// a zone from the admin panel and only receive its calls.
+type Zone struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	TicketFilter
+}
+
+// Validate checks a zone before it is stored
+func (z Zone) Validate() error {
+	invalid := func(reason string) error {
+		return fmt.Errorf("%w: %s", ErrInvalidZone, reason)
+	}
+
+	switch {
+	case z.Name == "":
+		return invalid("name is required")
+	case len(z.Name) > 100:
+		return invalid("name is longer than 100 characters")
+	case len(z.CategoryIDs) == 0 && len(z.Counters) == 0:
+		return invalid("choose at least one category or counter")
+	}
+	for _, n := range append(append([]int{}, z.CategoryIDs...), z.Counters...) {
+		if n <= 0 {
+			return invalid("categories and counters are positive numbers")
+		}
+	}
+	return nil
+}
+
+const zoneColumns = `id, name, category_ids, counters`
+
+func scanZone(row interface{ Scan(...interface{}) error }) (Zone, error) {
+	var z Zone
+	var categoryIDs, counters string
+	err := row.Scan(&z.ID, &z.Name, &categoryIDs, &counters)
+	z.CategoryIDs = parseIntList(categoryIDs)
+	z.Counters = parseIntList(counters)
+	return z, err
+}
+
+// ListZones returns every display zone by name
+func ListZones() ([]Zone, error) {
+	rows, err := database.DB.Query(`SELECT ` + zoneColumns + ` FROM display_zones ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	zones := []Zone{}
+	for rows.Next() {
+		z, err := scanZone(rows)
+		if err != nil {
+			continue
+		}
+		zones = append(zones, z)
+	}
+	return zones, nil
+}
+
+// GetZone finds a display zone by ID
+func GetZone(id int) (Zone, error) {
+	return scanZone(database.DB.QueryRow(`SELECT `+zoneColumns+` FROM display_zones WHERE id = ?`, id))
+}
+
+// CreateZone stores a new display zone
+func CreateZone(z Zone) (Zone, error) {
+	if err := z.Validate(); err != nil {
+		return Zone{}, err
+	}
+
+	res, err := database.DB.Exec(`
+		INSERT INTO display_zones (name, category_ids, counters) VALUES (?, ?, ?)
+	`, z.Name, formatIntList(z.CategoryIDs), formatIntList(z.Counters))
+	if err != nil {
+		return Zone{}, err
+	}
+
+	id, _ := res.LastInsertId()
+	return GetZone(int(id))
+}
+
+// UpdateZone replaces the name, categories and counters of a zone
+func UpdateZone(z Zone) (Zone, error) {
+	if err := z.Validate(); err != nil {
+		return Zone{}, err
+	}
+
+	if _, err := GetZone(z.ID); err != nil {
+		return Zone{}, err
+	}
+	_, err := database.DB.Exec(`
+		UPDATE display_zones SET name = ?, category_ids = ?, counters = ? WHERE id = ?
+	`, z.Name, formatIntList(z.CategoryIDs), formatIntList(z.Counters), z.ID)
+	if err != nil {
+		return Zone{}, err
+	}
+	return GetZone(z.ID)
+}
+
+// DeleteZone removes a zone; its displays go back to showing everything
+func DeleteZone(id int) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE devices SET zone_id = NULL WHERE zone_id = ?`, id); err != nil {
+		return err
+	}
+	res, err := tx.Exec(`DELETE FROM display_zones WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return tx.Commit()
+}
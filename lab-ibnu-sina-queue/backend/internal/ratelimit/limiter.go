@@ -0,0 +1,128 @@
+package ratelimit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rule allows at most Limit events per Window
+type Rule struct {
+	Limit  int
+	Window time.Duration
+}
+
+func (r Rule) String() string {
+	return fmt.Sprintf("%d/%s", r.Limit, r.Window)
+}
+
+// ParseRules reads a comma separated list of "limit/window" rules,
+// e.g. "3/30s,15/10m" allows a burst of 3 but no more than 15 per 10 minutes
+func ParseRules(spec string) ([]Rule, error) {
+	var rules []Rule
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		limit, window, ok := strings.Cut(part, "/")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate rule %q, expected limit/window", part)
+		}
+
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid limit in rate rule %q", part)
+		}
+		d, err := time.ParseDuration(window)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid window in rate rule %q", part)
+		}
+
+		rules = append(rules, Rule{Limit: n, Window: d})
+	}
+	return rules, nil
+}
+
+// Limiter enforces a set of sliding window rules per key
+type Limiter struct {
+	mu        sync.Mutex
+	rules     []Rule
+	maxWindow time.Duration
+	hits      map[string][]time.Time
+	lastSweep time.Time
+}
+
+func New(rules []Rule) *Limiter {
+	l := &Limiter{rules: rules, hits: make(map[string][]time.Time)}
+	for _, r := range rules {
+		if r.Window > l.maxWindow {
+			l.maxWindow = r.Window
+		}
+	}
+	return l
+}
+
+// Allow records an event for key unless a rule is exceeded. When denied it
+// returns how long the caller has to wait before trying again.
+func (l *Limiter) Allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	hits := prune(l.hits[key], now.Add(-l.maxWindow))
+	l.hits[key] = hits
+
+	var retryAfter time.Duration
+	for _, r := range l.rules {
+		start := now.Add(-r.Window)
+		var inWindow []time.Time
+		for _, t := range hits {
+			if t.After(start) {
+				inWindow = append(inWindow, t)
+			}
+		}
+		if len(inWindow) >= r.Limit {
+			// Wait until enough old hits leave the window
+			wait := inWindow[len(inWindow)-r.Limit].Add(r.Window).Sub(now)
+			if wait > retryAfter {
+				retryAfter = wait
+			}
+		}
+	}
+
+	if retryAfter > 0 {
+		return false, retryAfter
+	}
+
+	l.hits[key] = append(hits, now)
+	return true, 0
+}
+
+// sweep drops idle keys at most once a minute
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+
+	cutoff := now.Add(-l.maxWindow)
+	for key, hits := range l.hits {
+		if len(prune(hits, cutoff)) == 0 {
+			delete(l.hits, key)
+		}
+	}
+}
+
+// prune removes hits at or before cutoff; hits are in ascending order
+func prune(hits []time.Time, cutoff time.Time) []time.Time {
+	i := 0
+	for i < len(hits) && !hits[i].After(cutoff) {
+		i++
+	}
+	return hits[i:]
+}
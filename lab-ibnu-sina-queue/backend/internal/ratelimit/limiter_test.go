@@ -0,0 +1,121 @@
+package ratelimit
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseRules(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    []Rule
+		wantErr bool
+	}{
+		{"3/30s", []Rule{{3, 30 * time.Second}}, false},
+		{"3/30s, 15/10m", []Rule{{3, 30 * time.Second}, {15, 10 * time.Minute}}, false},
+		{"", nil, false},
+		{"3", nil, true},
+		{"0/30s", nil, true},
+		{"x/30s", nil, true},
+		{"3/soon", nil, true},
+		{"3/-1s", nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseRules(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseRules(%q) error = %v, want error %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseRules(%q) = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestAllowSlidingWindow(t *testing.T) {
+	start := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		rules    string
+		hits     []time.Duration // offsets from start
+		at       time.Duration
+		want     bool
+		wantWait time.Duration
+	}{
+		{"under the limit", "3/30s", []time.Duration{0, time.Second}, 2 * time.Second, true, 0},
+		{"at the limit", "3/30s", []time.Duration{0, time.Second, 2 * time.Second}, 3 * time.Second, false, 27 * time.Second},
+		{"oldest hit left the window", "3/30s", []time.Duration{0, time.Second, 2 * time.Second}, 30 * time.Second, true, 0},
+		{"denied until the oldest hit leaves", "3/30s", []time.Duration{0, 20 * time.Second, 25 * time.Second}, 29 * time.Second, false, time.Second},
+		{"window slides, not resets", "3/30s", []time.Duration{0, 20 * time.Second, 25 * time.Second, 31 * time.Second}, 32 * time.Second, false, 18 * time.Second},
+		{"longer rule still applies", "3/30s,4/10m", []time.Duration{0, time.Second, 2 * time.Second, time.Minute}, 2 * time.Minute, false, 8 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := ParseRules(tt.rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			l := New(rules)
+			for _, offset := range tt.hits {
+				if ok, _ := l.Allow("k", start.Add(offset)); !ok {
+					t.Fatalf("setup hit at %s denied", offset)
+				}
+			}
+
+			ok, wait := l.Allow("k", start.Add(tt.at))
+			if ok != tt.want || wait != tt.wantWait {
+				t.Errorf("Allow at %s = %v, %s; want %v, %s", tt.at, ok, wait, tt.want, tt.wantWait)
+			}
+		})
+	}
+}
+
+func TestAllowDeniedHitsDoNotCount(t *testing.T) {
+	start := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	l := New([]Rule{{Limit: 1, Window: 10 * time.Second}})
+
+	l.Allow("k", start)
+	for i := 1; i < 10; i++ {
+		if ok, _ := l.Allow("k", start.Add(time.Duration(i)*time.Second)); ok {
+			t.Fatalf("hit %d allowed over the limit", i)
+		}
+	}
+	// Retrying while limited must not push the window forward
+	if ok, _ := l.Allow("k", start.Add(10*time.Second)); !ok {
+		t.Error("denied after the window passed")
+	}
+}
+
+func TestAllowKeysAreIsolated(t *testing.T) {
+	now := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	l := New([]Rule{{Limit: 1, Window: time.Minute}})
+
+	if ok, _ := l.Allow("ip:10.0.0.1", now); !ok {
+		t.Fatal("first hit denied")
+	}
+	if ok, _ := l.Allow("ip:10.0.0.1", now); ok {
+		t.Error("second hit of the same key allowed")
+	}
+	if ok, _ := l.Allow("ip:10.0.0.2", now); !ok {
+		t.Error("another key was limited by the first")
+	}
+}
+
+func TestSweepDropsIdleKeys(t *testing.T) {
+	now := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	l := New([]Rule{{Limit: 5, Window: time.Minute}})
+
+	l.Allow("old", now)
+	l.Allow("new", now.Add(2*time.Minute))
+
+	if _, ok := l.hits["old"]; ok {
+		t.Error("idle key kept after its window passed")
+	}
+	if _, ok := l.hits["new"]; !ok {
+		t.Error("active key dropped")
+	}
+}
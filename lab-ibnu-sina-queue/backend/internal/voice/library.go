@@ -0,0 +1,173 @@
+// Package voice assembles call announcements from recorded WAV clips, so
+// every display plays the same voice instead of its browser's speech
+// synthesis.
+package voice
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+var ErrMissingClip = errors.New("missing voice clip")
+
+// Clips of the fixed parts of a call. The others are named after what they
+// say: letters ("a.wav"), and the number words of NumberWords ("dua.wav",
+// "belas.wav", "seratus.wav").
+const (
+	ClipIntro   = "nomor_antrian"
+	ClipCounter = "silakan_ke_loket"
+)
+
+// Pause is a phrase token played as a short silence
+const Pause = ","
+
+// cacheSize is how many assembled calls are kept in memory
+const cacheSize = 64
+
+// Library holds the clips of a directory in memory and the calls recently
+// assembled from them
+type Library struct {
+	format   Format
+	clips    map[string][]byte
+	pause    time.Duration
+	loadedAt time.Time
+
+	mu    sync.Mutex
+	cache map[string][]byte
+	order []string
+}
+
+// NewLibrary loads every .wav file in dir. The clip name is the file name
+// without extension, in lower case.
+func NewLibrary(dir string, pause time.Duration) (*Library, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.wav"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no .wav clips in %s", dir)
+	}
+
+	l := &Library{
+		clips:    make(map[string][]byte),
+		pause:    pause,
+		loadedAt: time.Now(),
+		cache:    make(map[string][]byte),
+	}
+	for i, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		format, samples, err := decodeWAV(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(path), err)
+		}
+		if i == 0 {
+			l.format = format
+		} else if format != l.format {
+			return nil, fmt.Errorf("%s is %s, expected %s: %w", filepath.Base(path), format, l.format, ErrFormatMismatch)
+		}
+
+		name := strings.ToLower(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+		l.clips[name] = samples
+	}
+	return l, nil
+}
+
+// Format is the audio format of the clips and assembled calls
+func (l *Library) Format() Format {
+	return l.format
+}
+
+// Len is the number of clips
+func (l *Library) Len() int {
+	return len(l.clips)
+}
+
+// LoadedAt is when the clips were read, the modification time of every
+// assembled call
+func (l *Library) LoadedAt() time.Time {
+	return l.loadedAt
+}
+
+// CallPhrase returns the clips of "Nomor antrian A nol dua belas, silakan
+// ke loket dua" for ticket code A-012 and counter 2
+func CallPhrase(code string, counter int) []string {
+	prefix, number, ok := strings.Cut(code, "-")
+	if !ok {
+		prefix, number = strings.TrimRightFunc(code, unicode.IsDigit), strings.TrimLeftFunc(code, unicode.IsLetter)
+	}
+
+	phrase := []string{ClipIntro}
+	for _, c := range strings.ToLower(prefix) {
+		phrase = append(phrase, string(c))
+	}
+	phrase = append(phrase, TicketNumberWords(number)...)
+	phrase = append(phrase, Pause, ClipCounter)
+	return append(phrase, NumberWords(counter)...)
+}
+
+// Assemble joins the clips of a phrase into one WAV file
+func (l *Library) Assemble(phrase []string) ([]byte, error) {
+	var parts [][]byte
+	size := 0
+	for _, token := range phrase {
+		part := l.clips[token]
+		if token == Pause {
+			part = l.format.silence(l.pause)
+		} else if part == nil {
+			return nil, fmt.Errorf("%w: %s.wav", ErrMissingClip, token)
+		}
+		parts = append(parts, part)
+		size += len(part)
+	}
+
+	samples := make([]byte, 0, size)
+	for _, part := range parts {
+		samples = append(samples, part...)
+	}
+	return encodeWAV(l.format, samples), nil
+}
+
+// Length is how long an assembled WAV file plays
+func (l *Library) Length(audio []byte) time.Duration {
+	frames := (len(audio) - 44) / l.format.frameSize()
+	return time.Duration(frames) * time.Second / time.Duration(l.format.SampleRate)
+}
+
+// Call returns the announcement of a ticket called to a counter,
+// assembling it on first use
+func (l *Library) Call(code string, counter int) ([]byte, error) {
+	key := fmt.Sprintf("%s/%d", code, counter)
+
+	l.mu.Lock()
+	audio, ok := l.cache[key]
+	l.mu.Unlock()
+	if ok {
+		return audio, nil
+	}
+
+	audio, err := l.Assemble(CallPhrase(code, counter))
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.cache[key]; !ok {
+		if len(l.order) >= cacheSize {
+			delete(l.cache, l.order[0])
+			l.order = l.order[1:]
+		}
+		l.cache[key] = audio
+		l.order = append(l.order, key)
+	}
+	return audio, nil
+}
@@ -0,0 +1,128 @@
+package voice
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+var (
+	ErrInvalidWAV     = errors.New("not a PCM WAV file")
+	ErrFormatMismatch = errors.New("clips have different audio formats")
+)
+
+// Format is the PCM format of a clip. All clips of a library must share it
+// so they can be joined without resampling.
+type Format struct {
+	Channels      uint16
+	SampleRate    uint32
+	BitsPerSample uint16
+}
+
+func (f Format) String() string {
+	return fmt.Sprintf("%d Hz, %d bit, %d channel(s)", f.SampleRate, f.BitsPerSample, f.Channels)
+}
+
+// frameSize is the number of bytes of one sample for every channel
+func (f Format) frameSize() int {
+	return int(f.Channels) * int(f.BitsPerSample) / 8
+}
+
+// silence returns d of silent samples
+func (f Format) silence(d time.Duration) []byte {
+	frames := int(int64(f.SampleRate) * int64(d) / int64(time.Second))
+	b := make([]byte, frames*f.frameSize())
+	if f.BitsPerSample == 8 {
+		// 8 bit PCM is unsigned, silence is the midpoint
+		for i := range b {
+			b[i] = 0x80
+		}
+	}
+	return b
+}
+
+// decodeWAV reads the format and samples of a RIFF WAV file, skipping
+// chunks other than "fmt " and "data"
+func decodeWAV(data []byte) (Format, []byte, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return Format{}, nil, ErrInvalidWAV
+	}
+
+	var format Format
+	var samples []byte
+	haveFormat := false
+	r := bytes.NewReader(data[12:])
+	for {
+		var header struct {
+			ID   [4]byte
+			Size uint32
+		}
+		if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+			break
+		}
+		// The size comes from the file, so check it before allocating
+		if int64(header.Size) > int64(r.Len()) {
+			return Format{}, nil, ErrInvalidWAV
+		}
+		body := make([]byte, header.Size)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return Format{}, nil, ErrInvalidWAV
+		}
+		// Chunks are padded to an even size
+		if header.Size%2 == 1 {
+			r.ReadByte()
+		}
+
+		switch string(header.ID[:]) {
+		case "fmt ":
+			if len(body) < 16 || binary.LittleEndian.Uint16(body[0:2]) != 1 {
+				return Format{}, nil, ErrInvalidWAV
+			}
+			format = Format{
+				Channels:      binary.LittleEndian.Uint16(body[2:4]),
+				SampleRate:    binary.LittleEndian.Uint32(body[4:8]),
+				BitsPerSample: binary.LittleEndian.Uint16(body[14:16]),
+			}
+			haveFormat = true
+		case "data":
+			samples = body
+		}
+	}
+
+	if !haveFormat || samples == nil || format.frameSize() == 0 {
+		return Format{}, nil, ErrInvalidWAV
+	}
+	// Lengths divide by the sample rate, and samples are whole bytes
+	if format.SampleRate == 0 || format.BitsPerSample%8 != 0 {
+		return Format{}, nil, ErrInvalidWAV
+	}
+	return format, samples, nil
+}
+
+// encodeWAV writes samples as a WAV file
+func encodeWAV(format Format, samples []byte) []byte {
+	var buf bytes.Buffer
+	buf.Grow(44 + len(samples))
+
+	write := func(v interface{}) { binary.Write(&buf, binary.LittleEndian, v) }
+	buf.WriteString("RIFF")
+	write(uint32(36 + len(samples)))
+	buf.WriteString("WAVE")
+
+	buf.WriteString("fmt ")
+	write(uint32(16))
+	write(uint16(1)) // PCM
+	write(format.Channels)
+	write(format.SampleRate)
+	write(format.SampleRate * uint32(format.frameSize()))
+	write(uint16(format.frameSize()))
+	write(format.BitsPerSample)
+
+	buf.WriteString("data")
+	write(uint32(len(samples)))
+	buf.Write(samples)
+	return buf.Bytes()
+}
@@ -0,0 +1,113 @@
+package voice
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWAVRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  Format
+		samples []byte
+	}{
+		{"mono 16 bit", Format{Channels: 1, SampleRate: 16000, BitsPerSample: 16}, []byte{1, 2, 3, 4, 5, 6}},
+		{"stereo 16 bit", Format{Channels: 2, SampleRate: 44100, BitsPerSample: 16}, []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		{"mono 8 bit odd size", Format{Channels: 1, SampleRate: 8000, BitsPerSample: 8}, []byte{0x80, 0x81, 0x7f}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format, samples, err := decodeWAV(encodeWAV(tt.format, tt.samples))
+			if err != nil {
+				t.Fatalf("decodeWAV: %v", err)
+			}
+			if format != tt.format {
+				t.Errorf("format = %v, want %v", format, tt.format)
+			}
+			if !bytes.Equal(samples, tt.samples) {
+				t.Errorf("samples = %v, want %v", samples, tt.samples)
+			}
+		})
+	}
+}
+
+func TestDecodeWAVSkipsOtherChunks(t *testing.T) {
+	format := Format{Channels: 1, SampleRate: 8000, BitsPerSample: 8}
+	wav := encodeWAV(format, []byte{1, 2})
+
+	// Put a padded odd sized LIST chunk between the header and fmt
+	list := []byte{'L', 'I', 'S', 'T', 3, 0, 0, 0, 'a', 'b', 'c', 0}
+	data := append(append(append([]byte{}, wav[:12]...), list...), wav[12:]...)
+	binary.LittleEndian.PutUint32(data[4:8], uint32(len(data)-8))
+
+	_, samples, err := decodeWAV(data)
+	if err != nil {
+		t.Fatalf("decodeWAV: %v", err)
+	}
+	if !bytes.Equal(samples, []byte{1, 2}) {
+		t.Errorf("samples = %v, want [1 2]", samples)
+	}
+}
+
+func TestDecodeWAVInvalid(t *testing.T) {
+	format := Format{Channels: 1, SampleRate: 8000, BitsPerSample: 16}
+	valid := encodeWAV(format, []byte{1, 2, 3, 4})
+
+	huge := append([]byte{}, valid...)
+	// The data chunk claims 4 GB
+	binary.LittleEndian.PutUint32(huge[40:44], 0xffffffff)
+
+	noData := append([]byte{}, valid[:36]...)
+
+	noRate := append([]byte{}, valid...)
+	binary.LittleEndian.PutUint32(noRate[24:28], 0)
+
+	oddBits := append([]byte{}, valid...)
+	binary.LittleEndian.PutUint16(oddBits[34:36], 12)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"not RIFF", []byte("RIFX\x00\x00\x00\x00WAVE")},
+		{"truncated", valid[:len(valid)-1]},
+		{"chunk larger than file", huge},
+		{"no data chunk", noData},
+		{"zero sample rate", noRate},
+		{"bit depth not whole bytes", oddBits},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := decodeWAV(tt.data); !errors.Is(err, ErrInvalidWAV) {
+				t.Errorf("decodeWAV error = %v, want %v", err, ErrInvalidWAV)
+			}
+		})
+	}
+}
+
+func TestSilence(t *testing.T) {
+	tests := []struct {
+		format Format
+		want   byte
+		size   int
+	}{
+		{Format{Channels: 1, SampleRate: 8000, BitsPerSample: 8}, 0x80, 800},
+		{Format{Channels: 2, SampleRate: 8000, BitsPerSample: 16}, 0, 3200},
+	}
+
+	for _, tt := range tests {
+		b := tt.format.silence(100 * time.Millisecond)
+		if len(b) != tt.size {
+			t.Errorf("%v: %d bytes of silence, want %d", tt.format, len(b), tt.size)
+		}
+		if len(b) > 0 && (b[0] != tt.want || b[len(b)-1] != tt.want) {
+			t.Errorf("%v: silence is %#x, want %#x", tt.format, b[0], tt.want)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package voice
+
+import (
+	"strconv"
+	"strings"
+)
+
+var digitWords = []string{"nol", "satu", "dua", "tiga", "empat", "lima", "enam", "tujuh", "delapan", "sembilan"}
+
+// NumberWords spells 0 to 9999 the way Indonesian is spoken, one clip
+// name per word: 12 is "dua belas", 115 "seratus lima belas"
+func NumberWords(n int) []string {
+	switch {
+	case n < 0 || n > 9999:
+		return digits(strconv.Itoa(n))
+	case n < 10:
+		return []string{digitWords[n]}
+	case n == 10:
+		return []string{"sepuluh"}
+	case n == 11:
+		return []string{"sebelas"}
+	case n < 20:
+		return []string{digitWords[n-10], "belas"}
+	case n < 100:
+		return append([]string{digitWords[n/10], "puluh"}, rest(n%10)...)
+	case n < 200:
+		return append([]string{"seratus"}, rest(n%100)...)
+	case n < 1000:
+		return append([]string{digitWords[n/100], "ratus"}, rest(n%100)...)
+	case n < 2000:
+		return append([]string{"seribu"}, rest(n%1000)...)
+	default:
+		return append([]string{digitWords[n/1000], "ribu"}, rest(n%1000)...)
+	}
+}
+
+// rest spells the remainder after a tens, hundreds or thousands word,
+// which is silent when zero
+func rest(n int) []string {
+	if n == 0 {
+		return nil
+	}
+	return NumberWords(n)
+}
+
+// TicketNumberWords spells the number of a ticket code. Leading zeros are
+// read out, so "012" is "nol dua belas" like staff call it.
+func TicketNumberWords(number string) []string {
+	trimmed := strings.TrimLeft(number, "0")
+	if trimmed == "" {
+		return []string{"nol"}
+	}
+	words := make([]string, 0, len(number))
+	for range number[:len(number)-len(trimmed)] {
+		words = append(words, "nol")
+	}
+
+	n := 0
+	for _, c := range trimmed {
+		if c < '0' || c > '9' {
+			return append(words, digits(trimmed)...)
+		}
+		n = n*10 + int(c-'0')
+		if n > 9999 {
+			return append(words, digits(trimmed)...)
+		}
+	}
+	return append(words, NumberWords(n)...)
+}
+
+// digits spells every digit on its own, for numbers too long to read out
+func digits(s string) []string {
+	var words []string
+	for _, c := range s {
+		if c >= '0' && c <= '9' {
+			words = append(words, digitWords[c-'0'])
+		}
+	}
+	return words
+}
@@ -0,0 +1,68 @@
+package voice
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNumberWords(t *testing.T) {
+	tests := []struct {
+		n    int
+		want []string
+	}{
+		{0, []string{"nol"}},
+		{7, []string{"tujuh"}},
+		{10, []string{"sepuluh"}},
+		{11, []string{"sebelas"}},
+		{12, []string{"dua", "belas"}},
+		{19, []string{"sembilan", "belas"}},
+		{20, []string{"dua", "puluh"}},
+		{45, []string{"empat", "puluh", "lima"}},
+		{100, []string{"seratus"}},
+		{111, []string{"seratus", "sebelas"}},
+		{115, []string{"seratus", "lima", "belas"}},
+		{300, []string{"tiga", "ratus"}},
+		{999, []string{"sembilan", "ratus", "sembilan", "puluh", "sembilan"}},
+		{1000, []string{"seribu"}},
+		{1010, []string{"seribu", "sepuluh"}},
+		{2024, []string{"dua", "ribu", "dua", "puluh", "empat"}},
+		{9999, []string{"sembilan", "ribu", "sembilan", "ratus", "sembilan", "puluh", "sembilan"}},
+		{12345, []string{"satu", "dua", "tiga", "empat", "lima"}},
+	}
+
+	for _, tt := range tests {
+		if got := NumberWords(tt.n); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("NumberWords(%d) = %v, want %v", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestTicketNumberWords(t *testing.T) {
+	tests := []struct {
+		number string
+		want   []string
+	}{
+		{"0", []string{"nol"}},
+		{"000", []string{"nol"}},
+		{"5", []string{"lima"}},
+		{"012", []string{"nol", "dua", "belas"}},
+		{"007", []string{"nol", "nol", "tujuh"}},
+		{"100", []string{"seratus"}},
+		{"0100", []string{"nol", "seratus"}},
+		{"1000", []string{"seribu"}},
+		{"12345", []string{"satu", "dua", "tiga", "empat", "lima"}},
+	}
+
+	for _, tt := range tests {
+		if got := TicketNumberWords(tt.number); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("TicketNumberWords(%q) = %v, want %v", tt.number, got, tt.want)
+		}
+	}
+}
+
+func TestCallPhrase(t *testing.T) {
+	want := []string{ClipIntro, "a", "nol", "dua", "belas", Pause, ClipCounter, "dua"}
+	if got := CallPhrase("A-012", 2); !reflect.DeepEqual(got, want) {
+		t.Errorf("CallPhrase(A-012, 2) = %v, want %v", got, want)
+	}
+}
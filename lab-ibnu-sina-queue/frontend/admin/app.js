@@ -6,6 +6,9 @@
 let currentCounter = 1;
 let currentCalledTicket = null;
 let waitingTickets = [];
+let heldTickets = [];
+let me = null;
+let lastBurst = null;
 
 // Initialize
 document.addEventListener('DOMContentLoaded', () => {
@@ -31,15 +34,20 @@ document.addEventListener('DOMContentLoaded', () => {
         });
     });
 
+    // Current user
+    loadCurrentUser();
+
     // Initial data load
     loadStats();
     loadWaitingTickets();
+    loadHeldTickets();
     loadVideoSettings();
 
     // Refresh every 5 seconds
     setInterval(() => {
         loadStats();
         loadWaitingTickets();
+        loadHeldTickets();
     }, 5000);
 });
 
@@ -48,17 +56,133 @@ const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
 const ws = new QueueWebSocket(`${protocol}//${window.location.host}/ws`, (message) => {
     console.log('Admin received:', message);
 
-    if (message.type === 'NEW_TICKET') {
+    if (message.type === 'SNAPSHOT') {
+        // Missed events while disconnected; reload everything
+        loadStats();
+        loadWaitingTickets();
+        loadHeldTickets();
+        showEmergencyStatus(message.data.emergency);
+    } else if (message.type === 'EMERGENCY') {
+        showEmergencyStatus(message.data);
+    } else if (message.type === 'NEW_TICKET') {
+        loadWaitingTickets();
+        loadStats();
+    } else if (message.type === 'HOLD_TICKET' || message.type === 'RELEASE_TICKET') {
+        loadWaitingTickets();
+        loadHeldTickets();
+        loadStats();
+    } else if (message.type === 'SUSPICIOUS_BURST') {
+        showBurst(message.data);
+    } else if (message.type === 'TICKETS_VOIDED') {
         loadWaitingTickets();
         loadStats();
     } else if (message.type === 'RESET_QUEUE') {
         loadWaitingTickets();
+        loadHeldTickets();
         loadStats();
         currentCalledTicket = null;
         document.getElementById('current-called').textContent = '--';
     }
 });
 
+// =====================
+// KIOSK BURST ALERTS
+// =====================
+
+function showBurst(burst) {
+    lastBurst = burst;
+    const source = burst.device_name ? `Kiosk "${burst.device_name}"` : `Alamat ${burst.ip}`;
+    const time = new Date(burst.at).toLocaleTimeString('id-ID', { hour: '2-digit', minute: '2-digit' });
+    document.getElementById('burst-alert-text').textContent =
+        `${source} mengambil terlalu banyak antrian (${time}). Batalkan tiket yang mencurigakan?`;
+    document.getElementById('burst-alert').style.display = 'flex';
+}
+
+function dismissBurst() {
+    lastBurst = null;
+    document.getElementById('burst-alert').style.display = 'none';
+}
+
+async function voidBurst() {
+    if (!lastBurst) return;
+    if (!confirm('Batalkan semua tiket menunggu dari sumber ini dalam 30 menit terakhir?')) return;
+
+    try {
+        const res = await apiFetch('/api/queue/void', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify(lastBurst.device_id
+                ? { device_id: lastBurst.device_id }
+                : { ip: lastBurst.ip })
+        });
+
+        if (!res.ok) throw new Error('Failed to void tickets');
+
+        const voided = await res.json();
+        alert(`${voided.length} tiket dibatalkan`);
+        dismissBurst();
+        loadWaitingTickets();
+        loadStats();
+    } catch (err) {
+        console.error('Error voiding tickets:', err);
+        alert('Gagal membatalkan tiket');
+    }
+}
+
+// =====================
+// EMERGENCY OVERRIDE
+// =====================
+
+function showEmergencyStatus(emergency) {
+    const banner = document.getElementById('emergency-alert');
+    if (!emergency || !emergency.active) {
+        banner.style.display = 'none';
+        return;
+    }
+    const since = new Date(emergency.started_at).toLocaleTimeString('id-ID', { hour: '2-digit', minute: '2-digit' });
+    document.getElementById('emergency-alert-text').textContent =
+        `MODE DARURAT AKTIF sejak ${since} (${emergency.started_by}): ${emergency.message}. Pengambilan antrian dihentikan.`;
+    banner.style.display = 'flex';
+}
+
+async function startEmergency() {
+    const message = document.getElementById('emergency-message').value.trim();
+    if (!message) {
+        alert('Masukkan pesan darurat');
+        return;
+    }
+    if (!confirm('Tampilkan pesan darurat di semua display dan kiosk?')) return;
+
+    try {
+        const res = await apiFetch('/api/emergency', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ message: message })
+        });
+        if (!res.ok) {
+            alert('Gagal menyiarkan: ' + await res.text());
+            return;
+        }
+        document.getElementById('emergency-message').value = '';
+        showEmergencyStatus(await res.json());
+    } catch (err) {
+        console.error('Error starting emergency:', err);
+    }
+}
+
+async function clearEmergency() {
+    if (!confirm('Nyatakan aman dan kembalikan semua display dan kiosk ke normal?')) return;
+
+    try {
+        const res = await apiFetch('/api/emergency/clear', { method: 'POST' });
+        if (!res.ok && res.status !== 409) throw new Error('Failed to clear emergency');
+        showEmergencyStatus(null);
+    } catch (err) {
+        console.error('Error clearing emergency:', err);
+        alert('Gagal menyatakan aman');
+    }
+}
+
 // =====================
 // NAVIGATION
 // =====================
@@ -78,9 +202,402 @@ function switchSection(section) {
     // Update title
     const titles = {
         'queue': 'Panggil Antrian',
-        'settings': 'Pengaturan Display'
+        'settings': 'Pengaturan Display',
+        'devices': 'Perangkat',
+        'users': 'Pengguna'
     };
     document.getElementById('page-title').textContent = titles[section] || 'Admin';
+
+    if (section === 'settings') {
+        loadPlaylist();
+        loadMedia();
+        loadAnnouncements();
+    }
+    if (section === 'devices') {
+        loadZones().then(loadDevices);
+        loadLiveClients();
+    }
+    if (section === 'users') loadUsers();
+}
+
+// =====================
+// ACCOUNT
+// =====================
+
+const roleNames = {
+    admin: 'Admin',
+    supervisor: 'Supervisor',
+    counter: 'Petugas Loket',
+    display: 'Display',
+    kiosk: 'Kiosk'
+};
+
+async function loadCurrentUser() {
+    try {
+        me = await currentUser();
+        document.getElementById('user-name').textContent = me.display_name || me.username;
+        document.getElementById('user-role').textContent = roleNames[me.role] || me.role;
+
+        // Hide menus the role cannot use
+        document.querySelectorAll('[data-permission]').forEach(el => {
+            el.style.display = el.dataset.permission.split(' ').includes(me.role) ? '' : 'none';
+        });
+        if (me.role === 'counter') {
+            document.getElementById('btn-reset').style.display = 'none';
+        }
+    } catch (err) {
+        console.error('Error loading current user:', err);
+    }
+}
+
+// =====================
+// DEVICES
+// =====================
+
+const deviceStatusNames = {
+    pending: 'Menunggu pemasangan',
+    active: 'Aktif',
+    revoked: 'Dicabut'
+};
+
+async function loadDevices() {
+    try {
+        const res = await apiFetch('/api/devices');
+        if (!res.ok) return;
+        const devices = await res.json();
+
+        const tbody = document.getElementById('devices-list');
+        tbody.innerHTML = '';
+        devices.forEach(device => {
+            const lastHeartbeat = device.last_heartbeat_at
+                ? new Date(device.last_heartbeat_at).toLocaleString('id-ID')
+                : '-';
+            const status = device.status === 'active'
+                ? (device.online ? 'Online' : 'Offline')
+                : deviceStatusNames[device.status] || device.status;
+            const tr = document.createElement('tr');
+            tr.innerHTML = `
+                <td>${device.name}</td>
+                <td>${roleNames[device.role] || device.role}</td>
+                <td>${status}</td>
+                <td>${device.location || '-'} / ${device.version || '-'}</td>
+                <td>${lastHeartbeat}</td>
+                <td>
+                    ${device.online ? deviceControls(device) : ''}
+                    ${device.role === 'display' && device.status === 'active' ? zoneSelect(device) : ''}
+                    <button class="btn btn-secondary" onclick="reissuePairingCode(${device.id})">Kode Baru</button>
+                    ${device.status !== 'revoked' ? `<button class="btn btn-danger" onclick="revokeDevice(${device.id})">Cabut</button>` : ''}
+                </td>
+            `;
+            fillZoneNames(tr);
+            tbody.appendChild(tr);
+        });
+    } catch (err) {
+        console.error('Error loading devices:', err);
+    }
+}
+
+const deviceLayoutNames = {
+    standard: 'Standar',
+    'calls-only': 'Hanya Panggilan',
+    mirrored: 'Cermin',
+    board: 'Papan Semua Loket'
+};
+
+// deviceControls renders the remote commands for a connected device
+function deviceControls(device) {
+    const buttons = [
+        `<button class="btn btn-secondary" onclick="sendDeviceCommand(${device.id}, {command: 'identify'})">Identifikasi</button>`,
+        `<button class="btn btn-secondary" onclick="sendDeviceCommand(${device.id}, {command: 'reload'})">Muat Ulang</button>`
+    ];
+    if (device.role === 'display') {
+        const options = Object.entries(deviceLayoutNames)
+            .map(([value, name]) => `<option value="${value}" ${device.layout === value ? 'selected' : ''}>${name}</option>`)
+            .join('');
+        buttons.push(
+            `<select onchange="sendDeviceCommand(${device.id}, {command: 'layout', layout: this.value})">${options}</select>`,
+            `<button class="btn btn-secondary" onclick="sendDeviceCommand(${device.id}, {command: 'mute', muted: ${!device.muted}})">${device.muted ? 'Nyalakan Suara' : 'Bisukan'}</button>`
+        );
+    }
+    return buttons.join(' ');
+}
+
+async function sendDeviceCommand(deviceId, command) {
+    try {
+        const res = await apiFetch(`/api/devices/${deviceId}/command`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify(command)
+        });
+        if (!res.ok) {
+            alert('Gagal mengirim perintah: ' + await res.text());
+            return;
+        }
+        loadDevices();
+    } catch (err) {
+        console.error('Error sending device command:', err);
+    }
+}
+
+async function loadLiveClients() {
+    try {
+        const res = await apiFetch('/api/ws/clients');
+        if (!res.ok) return;
+        const clients = await res.json();
+
+        const formatTime = (t) => t ? new Date(t).toLocaleTimeString('id-ID') : '-';
+        const tbody = document.getElementById('live-clients-list');
+        tbody.innerHTML = '';
+        clients.forEach(client => {
+            const tr = document.createElement('tr');
+            tr.innerHTML = `
+                <td>${client.username} (${roleNames[client.role] || client.role})${client.location ? ' - ' + client.location : ''}</td>
+                <td>${client.ip}</td>
+                <td>${formatTime(client.connected_at)}</td>
+                <td>${formatTime(client.last_pong_at)}</td>
+                <td>${client.sent} / ${client.dropped}</td>
+            `;
+            tbody.appendChild(tr);
+        });
+    } catch (err) {
+        console.error('Error loading live clients:', err);
+    }
+}
+
+function showPairingCode(pairing) {
+    const expires = new Date(pairing.device.pairing_expires_at).toLocaleTimeString('id-ID', { hour: '2-digit', minute: '2-digit' });
+    document.getElementById('pairing-code').textContent = `${pairing.pairing_code} (berlaku s.d. ${expires})`;
+}
+
+async function createDevice() {
+    try {
+        const res = await apiFetch('/api/devices', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({
+                name: document.getElementById('new-device-name').value.trim(),
+                role: document.getElementById('new-device-role').value
+            })
+        });
+
+        if (!res.ok) {
+            alert('Gagal mendaftarkan perangkat: ' + await res.text());
+            return;
+        }
+
+        showPairingCode(await res.json());
+        document.getElementById('new-device-name').value = '';
+        loadDevices();
+    } catch (err) {
+        console.error('Error creating device:', err);
+    }
+}
+
+async function reissuePairingCode(deviceId) {
+    if (!confirm('Kunci perangkat saat ini akan berhenti berlaku. Lanjutkan?')) return;
+
+    try {
+        const res = await apiFetch(`/api/devices/${deviceId}/pairing-code`, { method: 'POST' });
+        if (!res.ok) throw new Error('Failed to reissue pairing code');
+
+        showPairingCode(await res.json());
+        loadDevices();
+    } catch (err) {
+        console.error('Error reissuing pairing code:', err);
+        alert('Gagal membuat kode pemasangan');
+    }
+}
+
+async function revokeDevice(deviceId) {
+    if (!confirm('Cabut akses perangkat ini?')) return;
+
+    try {
+        const res = await apiFetch(`/api/devices/${deviceId}/revoke`, { method: 'POST' });
+        if (!res.ok) throw new Error('Failed to revoke device');
+        loadDevices();
+    } catch (err) {
+        console.error('Error revoking device:', err);
+        alert('Gagal mencabut perangkat');
+    }
+}
+
+async function loadUsers() {
+    try {
+        const res = await apiFetch('/api/users');
+        if (!res.ok) return;
+        const users = await res.json();
+
+        const tbody = document.getElementById('users-list');
+        tbody.innerHTML = '';
+        users.forEach(user => {
+            const tr = document.createElement('tr');
+            tr.innerHTML = `
+                <td>${user.username}</td>
+                <td>${user.display_name || '-'}</td>
+                <td>${roleNames[user.role] || user.role}</td>
+                <td>${user.active ? 'Aktif' : 'Nonaktif'}</td>
+                <td>
+                    <button class="btn btn-secondary" onclick="toggleUser(${user.id}, ${!user.active})">
+                        ${user.active ? 'Nonaktifkan' : 'Aktifkan'}
+                    </button>
+                </td>
+            `;
+            tbody.appendChild(tr);
+        });
+    } catch (err) {
+        console.error('Error loading users:', err);
+    }
+}
+
+async function createUser() {
+    try {
+        const res = await apiFetch('/api/users', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({
+                username: document.getElementById('new-username').value.trim(),
+                display_name: document.getElementById('new-display-name').value.trim(),
+                password: document.getElementById('new-password').value,
+                role: document.getElementById('new-role').value
+            })
+        });
+
+        if (!res.ok) {
+            alert('Gagal menambah pengguna: ' + await res.text());
+            return;
+        }
+
+        document.getElementById('new-username').value = '';
+        document.getElementById('new-display-name').value = '';
+        document.getElementById('new-password').value = '';
+        loadUsers();
+    } catch (err) {
+        console.error('Error creating user:', err);
+    }
+}
+
+async function toggleUser(userId, active) {
+    try {
+        const res = await apiFetch(`/api/users/${userId}`, {
+            method: 'PUT',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ active: active })
+        });
+
+        if (!res.ok) {
+            alert('Gagal mengubah pengguna: ' + await res.text());
+            return;
+        }
+        loadUsers();
+    } catch (err) {
+        console.error('Error updating user:', err);
+    }
+}
+
+// =====================
+// DISPLAY ZONES
+// =====================
+
+const zoneCategoryNames = {
+    1: 'A - Periksa Lab',
+    2: 'B - PCR / Swab',
+    3: 'C - Pengambilan Hasil'
+};
+
+const zoneCounters = [1, 2, 3];
+
+let zones = [];
+
+function renderZonePickers() {
+    const categories = document.getElementById('new-zone-categories');
+    if (categories.children.length > 0) return;
+    categories.innerHTML = Object.entries(zoneCategoryNames)
+        .map(([id, name]) => `<label><input type="checkbox" value="${id}"> ${name}</label>`)
+        .join('');
+    document.getElementById('new-zone-counters').innerHTML = zoneCounters
+        .map(n => `<label><input type="checkbox" value="${n}"> Loket ${n}</label>`)
+        .join('');
+}
+
+// zoneSelect renders the zone picker of a display; fillZoneNames sets the
+// option labels once it is in the page
+function zoneSelect(device) {
+    const options = [`<option value="0">Semua Zona</option>`]
+        .concat(zones.map(z => `<option value="${z.id}" ${device.zone_id === z.id ? 'selected' : ''}>${z.id}</option>`))
+        .join('');
+    return `<select class="zone-select" onchange="sendDeviceCommand(${device.id}, {command: 'zone', zone_id: parseInt(this.value)})">${options}</select>`;
+}
+
+function fillZoneNames(container) {
+    container.querySelectorAll('.zone-select option').forEach(option => {
+        const zone = zones.find(z => z.id === parseInt(option.value));
+        if (zone) option.textContent = 'Zona: ' + zone.name;
+    });
+}
+
+async function loadZones() {
+    renderZonePickers();
+    try {
+        const res = await apiFetch('/api/zones');
+        if (!res.ok) return;
+        zones = await res.json();
+
+        const tbody = document.getElementById('zones-list');
+        tbody.innerHTML = '';
+        zones.forEach(zone => {
+            const tr = document.createElement('tr');
+            tr.innerHTML = `
+                <td class="zone-name"></td>
+                <td>${zone.category_ids.length > 0 ? zone.category_ids.map(id => zoneCategoryNames[id] || id).join(', ') : 'Semua'}</td>
+                <td>${zone.counters.length > 0 ? zone.counters.map(n => 'Loket ' + n).join(', ') : 'Semua'}</td>
+                <td><button class="btn btn-danger" onclick="deleteZone(${zone.id})">Hapus</button></td>
+            `;
+            tr.querySelector('.zone-name').textContent = zone.name;
+            tbody.appendChild(tr);
+        });
+    } catch (err) {
+        console.error('Error loading zones:', err);
+    }
+}
+
+async function createZone() {
+    const checked = id => [...document.querySelectorAll(`#${id} input:checked`)].map(el => parseInt(el.value));
+    const zone = {
+        name: document.getElementById('new-zone-name').value.trim(),
+        category_ids: checked('new-zone-categories'),
+        counters: checked('new-zone-counters')
+    };
+
+    try {
+        const res = await apiFetch('/api/zones', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify(zone)
+        });
+        if (!res.ok) {
+            alert('Gagal menambah zona: ' + await res.text());
+            return;
+        }
+
+        document.getElementById('new-zone-name').value = '';
+        document.querySelectorAll('#new-zone-categories input, #new-zone-counters input').forEach(el => { el.checked = false; });
+        loadZones().then(loadDevices);
+    } catch (err) {
+        console.error('Error creating zone:', err);
+    }
+}
+
+async function deleteZone(id) {
+    if (!confirm('Hapus zona ini? Display di zona ini akan kembali menampilkan semua panggilan.')) return;
+
+    try {
+        const res = await apiFetch(`/api/zones/${id}`, { method: 'DELETE' });
+        if (!res.ok) throw new Error('Failed to delete zone');
+        loadZones().then(loadDevices);
+    } catch (err) {
+        console.error('Error deleting zone:', err);
+        alert('Gagal menghapus zona');
+    }
 }
 
 // =====================
@@ -89,21 +606,29 @@ function switchSection(section) {
 
 async function loadStats() {
     try {
-        const res = await fetch('/api/queue/stats');
+        const res = await apiFetch('/api/queue/stats');
         const stats = await res.json();
 
         document.getElementById('stat-waiting').textContent = stats.waiting || 0;
         document.getElementById('stat-calling').textContent = stats.calling || 0;
         document.getElementById('stat-finished').textContent = stats.finished || 0;
         document.getElementById('stat-total').textContent = stats.total || 0;
+        document.getElementById('timing-summary').textContent =
+            `Rata-rata tunggu ${formatDuration(stats.avg_wait_seconds)} · layanan ${formatDuration(stats.avg_service_seconds)}`;
     } catch (err) {
         console.error('Error loading stats:', err);
     }
 }
 
+function formatDuration(seconds) {
+    if (!seconds) return '--';
+    const minutes = Math.floor(seconds / 60);
+    return minutes > 0 ? `${minutes} mnt` : `${seconds} dtk`;
+}
+
 async function loadWaitingTickets() {
     try {
-        const res = await fetch('/api/queue/waiting');
+        const res = await apiFetch('/api/queue/waiting');
         waitingTickets = await res.json() || [];
 
         renderQueueLists();
@@ -156,6 +681,7 @@ function renderQueueLists() {
                 </div>
                 <div class="queue-item-actions">
                     <button class="btn btn-primary" onclick="callTicket(${ticket.id})">Panggil</button>
+                    <button class="btn btn-secondary" onclick="holdTicket(${ticket.id})">Tahan</button>
                     <button class="btn btn-secondary" onclick="skipTicket(${ticket.id})">Skip</button>
                 </div>
             `;
@@ -164,21 +690,71 @@ function renderQueueLists() {
     });
 }
 
+async function loadHeldTickets() {
+    try {
+        const res = await apiFetch('/api/queue/held');
+        heldTickets = await res.json() || [];
+
+        renderHeldList();
+    } catch (err) {
+        console.error('Error loading held tickets:', err);
+    }
+}
+
+function renderHeldList() {
+    const list = document.getElementById('queue-held');
+    document.getElementById('count-held').textContent = heldTickets.length;
+    list.innerHTML = '';
+
+    if (heldTickets.length === 0) {
+        list.innerHTML = '<div class="empty-queue">Tidak ada antrian ditahan</div>';
+        return;
+    }
+
+    heldTickets.forEach(ticket => {
+        const until = ticket.hold_until
+            ? 'sampai ' + new Date(ticket.hold_until).toLocaleTimeString('id-ID', { hour: '2-digit', minute: '2-digit' })
+            : 'sampai diaktifkan';
+        const div = document.createElement('div');
+        div.className = 'queue-item';
+        div.innerHTML = `
+            <div>
+                <div class="queue-item-code">${ticket.formatted_code}</div>
+                <div class="queue-item-reason">${ticket.hold_reason || '-'}</div>
+                <div class="queue-item-time">${until}</div>
+            </div>
+            <div class="queue-item-actions">
+                <button class="btn btn-primary" onclick="releaseTicket(${ticket.id})">Aktifkan</button>
+            </div>
+        `;
+        list.appendChild(div);
+    });
+}
+
 // =====================
 // QUEUE ACTIONS
 // =====================
 
-async function callTicket(ticketId) {
-    try {
-        const res = await fetch('/api/queue/call', {
-            method: 'POST',
-            headers: { 'Content-Type': 'application/json' },
-            body: JSON.stringify({ ticket_id: ticketId, counter: currentCounter })
-        });
+// Counter actions go over the WebSocket when it is connected and fall back
+// to the REST endpoint otherwise. Both run the same server code.
+async function counterAction(command, payload, url) {
+    const id = actionIdempotencyKey(command + JSON.stringify(payload));
+    if (ws.isOpen()) {
+        return ws.command(command, payload, id);
+    }
 
-        if (!res.ok) throw new Error('Failed to call ticket');
+    const res = await postAction(url, payload);
+    if (!res.ok) {
+        const err = new Error(await res.text());
+        err.code = res.status;
+        throw err;
+    }
+    return res.json();
+}
 
-        const ticket = await res.json();
+async function callTicket(ticketId) {
+    try {
+        const ticket = await counterAction('call', { ticket_id: ticketId, counter: currentCounter }, '/api/queue/call');
         currentCalledTicket = ticket;
         document.getElementById('current-called').textContent = ticket.formatted_code;
 
@@ -193,20 +769,13 @@ async function callTicket(ticketId) {
 
 async function recallTicket() {
     try {
-        const res = await fetch('/api/queue/recall', {
-            method: 'POST',
-            headers: { 'Content-Type': 'application/json' },
-            body: JSON.stringify({ counter: currentCounter })
-        });
-
-        if (!res.ok) {
+        const ticket = await counterAction('recall', { counter: currentCounter }, '/api/queue/recall');
+        console.log('Recalled:', ticket.formatted_code);
+    } catch (err) {
+        if (err.code === 404) {
             alert('Tidak ada antrian untuk dipanggil ulang');
             return;
         }
-
-        const ticket = await res.json();
-        console.log('Recalled:', ticket.formatted_code);
-    } catch (err) {
         console.error('Error recalling ticket:', err);
     }
 }
@@ -215,13 +784,7 @@ async function skipTicket(ticketId) {
     if (!confirm('Yakin ingin skip antrian ini?')) return;
 
     try {
-        const res = await fetch('/api/queue/skip', {
-            method: 'POST',
-            headers: { 'Content-Type': 'application/json' },
-            body: JSON.stringify({ ticket_id: ticketId })
-        });
-
-        if (!res.ok) throw new Error('Failed to skip');
+        await counterAction('skip', { ticket_id: ticketId }, '/api/queue/skip');
 
         loadWaitingTickets();
         loadStats();
@@ -230,20 +793,64 @@ async function skipTicket(ticketId) {
     }
 }
 
-async function finishTicket() {
+async function holdTicket(ticketId) {
+    const reason = prompt('Alasan ditahan (contoh: menunggu konfirmasi puasa):');
+    if (reason === null) return;
+
+    const minutes = parseInt(prompt('Aktifkan otomatis setelah berapa menit? (kosongkan untuk manual)') || '0');
+
+    try {
+        const res = await postAction('/api/queue/hold', { ticket_id: ticketId, reason: reason, minutes: minutes || 0 });
+
+        if (!res.ok) throw new Error('Failed to hold');
+
+        loadWaitingTickets();
+        loadHeldTickets();
+        loadStats();
+    } catch (err) {
+        console.error('Error holding ticket:', err);
+        alert('Gagal menahan antrian');
+    }
+}
+
+async function releaseTicket(ticketId) {
+    try {
+        const res = await postAction('/api/queue/release', { ticket_id: ticketId });
+
+        if (!res.ok) throw new Error('Failed to release');
+
+        loadWaitingTickets();
+        loadHeldTickets();
+        loadStats();
+    } catch (err) {
+        console.error('Error releasing ticket:', err);
+        alert('Gagal mengaktifkan antrian');
+    }
+}
+
+async function serveTicket() {
     if (!currentCalledTicket) {
         alert('Tidak ada antrian yang sedang dipanggil');
         return;
     }
 
     try {
-        const res = await fetch('/api/queue/finish', {
-            method: 'POST',
-            headers: { 'Content-Type': 'application/json' },
-            body: JSON.stringify({ ticket_id: currentCalledTicket.id })
-        });
+        currentCalledTicket = await counterAction('serve', { ticket_id: currentCalledTicket.id }, '/api/queue/serve');
+        loadStats();
+    } catch (err) {
+        console.error('Error serving ticket:', err);
+        alert('Gagal memulai layanan');
+    }
+}
+
+async function finishTicket() {
+    if (!currentCalledTicket) {
+        alert('Tidak ada antrian yang sedang dipanggil');
+        return;
+    }
 
-        if (!res.ok) throw new Error('Failed to finish');
+    try {
+        await counterAction('finish', { ticket_id: currentCalledTicket.id }, '/api/queue/finish');
 
         currentCalledTicket = null;
         document.getElementById('current-called').textContent = '--';
@@ -259,7 +866,7 @@ async function resetQueue() {
     if (!confirm('Konfirmasi sekali lagi: Reset semua antrian?')) return;
 
     try {
-        const res = await fetch('/api/queue/reset', {
+        const res = await apiFetch('/api/queue/reset', {
             method: 'POST',
             headers: { 'Content-Type': 'application/json' }
         });
@@ -283,12 +890,7 @@ async function finishAndCallNext() {
     // 1. Finish Current if exists
     if (currentCalledTicket) {
         try {
-            const res = await fetch('/api/queue/finish', {
-                method: 'POST',
-                headers: { 'Content-Type': 'application/json' },
-                body: JSON.stringify({ ticket_id: currentCalledTicket.id })
-            });
-            if (!res.ok) throw new Error('Failed to finish');
+            await counterAction('finish', { ticket_id: currentCalledTicket.id }, '/api/queue/finish');
 
             // UI update (clear current)
             currentCalledTicket = null;
@@ -300,33 +902,32 @@ async function finishAndCallNext() {
         }
     }
 
-    // 2. Refresh lists to get latest status
-    await loadWaitingTickets();
-
-    // 3. Call Next
-    if (waitingTickets.length > 0) {
-        await callNextTicket();
-    } else {
-        alert('Antrian saat ini selesai. Tidak ada antrian berikutnya.');
-        loadStats();
-    }
+    // 2. Call Next
+    await callNextTicket('Antrian saat ini selesai. Tidak ada antrian berikutnya.');
 }
 
 // =====================
 // MANUAL INPUT
 // =====================
 
-async function callNextTicket() {
-    if (waitingTickets.length === 0) {
-        alert('Tidak ada antrian yang menunggu saat ini.');
-        return;
+async function callNextTicket(emptyMessage) {
+    // The server picks the oldest waiting ticket, so two counters never
+    // call the same one
+    try {
+        const ticket = await counterAction('call-next', { counter: currentCounter }, '/api/queue/call-next');
+        currentCalledTicket = ticket;
+        document.getElementById('current-called').textContent = ticket.formatted_code;
+    } catch (err) {
+        if (err.code === 404) {
+            alert(emptyMessage || 'Tidak ada antrian yang menunggu saat ini.');
+        } else {
+            console.error('Error calling next ticket:', err);
+            alert('Gagal memanggil antrian');
+        }
     }
 
-    // Sort by ID (just in case) and pick the first one
-    const nextTicket = waitingTickets.sort((a, b) => a.id - b.id)[0];
-
-    // Call it
-    await callTicket(nextTicket.id);
+    loadWaitingTickets();
+    loadStats();
 }
 
 async function callManualTicket() {
@@ -337,13 +938,9 @@ async function callManualTicket() {
     }
 
     try {
-        const res = await fetch('/api/queue/call-manual', {
-            method: 'POST',
-            headers: { 'Content-Type': 'application/json' },
-            body: JSON.stringify({
-                code: code,
-                counter: currentCounter
-            })
+        const res = await postAction('/api/queue/call-manual', {
+            code: code,
+            counter: currentCounter
         });
 
         if (res.status === 404) {
@@ -372,11 +969,7 @@ async function createManualTicket() {
     const categoryId = parseInt(document.getElementById('manual-category').value);
 
     try {
-        const res = await fetch('/api/queue/create', {
-            method: 'POST',
-            headers: { 'Content-Type': 'application/json' },
-            body: JSON.stringify({ category_id: categoryId })
-        });
+        const res = await postAction('/api/queue/create', { category_id: categoryId });
 
         if (!res.ok) throw new Error('Failed to create ticket');
 
@@ -401,7 +994,7 @@ async function updateVideoSettings() {
     const subtitle = document.getElementById('video-subtitle').value;
 
     try {
-        const res = await fetch('/api/display/video', {
+        const res = await apiFetch('/api/display/video', {
             method: 'POST',
             headers: { 'Content-Type': 'application/json' },
             body: JSON.stringify({
@@ -422,7 +1015,7 @@ async function updateVideoSettings() {
 
 async function loadVideoSettings() {
     try {
-        const res = await fetch('/api/display/video');
+        const res = await apiFetch('/api/display/video');
         if (res.ok) {
             const data = await res.json();
             document.getElementById('video-url').value = data.video_url || '';
@@ -433,3 +1026,353 @@ async function loadVideoSettings() {
         console.error('Error loading video settings:', err);
     }
 }
+
+// =====================
+// MEDIA LIBRARY
+// =====================
+
+// Files served by this server keep playing when the internet is down,
+// unlike YouTube
+function formatSize(bytes) {
+    if (bytes >= 1 << 20) return (bytes / (1 << 20)).toFixed(1) + ' MB';
+    return Math.max(1, Math.round(bytes / 1024)) + ' KB';
+}
+
+async function loadMedia() {
+    try {
+        const res = await apiFetch('/api/media');
+        if (!res.ok) return;
+        const files = await res.json();
+
+        const tbody = document.getElementById('media-list');
+        tbody.innerHTML = '';
+        files.forEach(file => {
+            const tr = document.createElement('tr');
+            tr.innerHTML = `
+                <td><a target="_blank" class="media-name"></a></td>
+                <td>${file.kind === 'video' ? 'Video' : 'Gambar'}</td>
+                <td>${formatSize(file.size)}</td>
+                <td>
+                    ${file.kind === 'video' ? `<button class="btn btn-secondary" onclick="useMedia('video-url', '${file.url}')">Jadikan Video Display</button>` : ''}
+                    <button class="btn btn-secondary" onclick="useMedia('playlist-media-url', '${file.url}', '${file.kind}')">Pakai di Playlist</button>
+                    <button class="btn btn-danger" onclick="deleteMedia(${file.id})">Hapus</button>
+                </td>
+            `;
+            const link = tr.querySelector('.media-name');
+            link.href = file.url;
+            link.textContent = file.name;
+            tbody.appendChild(tr);
+        });
+    } catch (err) {
+        console.error('Error loading media:', err);
+    }
+}
+
+async function uploadMedia() {
+    const input = document.getElementById('media-file');
+    if (input.files.length === 0) return;
+
+    const status = document.getElementById('media-upload-status');
+    const form = new FormData();
+    form.append('file', input.files[0]);
+    status.textContent = ' Mengunggah...';
+
+    try {
+        const res = await apiFetch('/api/media', { method: 'POST', body: form });
+        if (!res.ok) {
+            status.textContent = '';
+            alert('Gagal mengunggah: ' + await res.text());
+            return;
+        }
+        status.textContent = '';
+        input.value = '';
+        loadMedia();
+    } catch (err) {
+        status.textContent = '';
+        console.error('Error uploading media:', err);
+    }
+}
+
+// useMedia puts the URL of a library file into a form field
+function useMedia(fieldId, url, kind) {
+    document.getElementById(fieldId).value = url;
+    if (kind) document.getElementById('playlist-kind').value = kind;
+    document.getElementById(fieldId).scrollIntoView({ behavior: 'smooth', block: 'center' });
+}
+
+async function deleteMedia(id) {
+    if (!confirm('Hapus file ini dari pustaka media?')) return;
+
+    try {
+        const res = await apiFetch(`/api/media/${id}`, { method: 'DELETE' });
+        if (!res.ok) {
+            alert('Gagal menghapus: ' + await res.text());
+            return;
+        }
+        loadMedia();
+    } catch (err) {
+        console.error('Error deleting media:', err);
+    }
+}
+
+// =====================
+// PLAYLIST
+// =====================
+
+const playlistKindNames = {
+    video: 'Video',
+    image: 'Gambar',
+    text: 'Teks'
+};
+
+const weekdayNames = ['Sen', 'Sel', 'Rab', 'Kam', 'Jum', 'Sab', 'Min'];
+
+let playlist = [];
+
+function renderWeekdayPicker() {
+    const container = document.getElementById('playlist-weekdays');
+    if (container.children.length > 0) return;
+    container.innerHTML = weekdayNames
+        .map((name, i) => `<label><input type="checkbox" value="${i + 1}"> ${name}</label>`)
+        .join('');
+}
+
+// describeSchedule summarizes when an item plays
+function describeSchedule(item) {
+    const parts = [];
+    if (item.starts_on || item.ends_on) parts.push(`${item.starts_on || '...'} s.d. ${item.ends_on || '...'}`);
+    if (item.daily_start) parts.push(`${item.daily_start}-${item.daily_end}`);
+    if (item.weekdays && item.weekdays.length > 0) parts.push(item.weekdays.map(d => weekdayNames[d - 1]).join(', '));
+    return parts.length > 0 ? parts.join(' / ') : 'Selalu';
+}
+
+async function loadPlaylist() {
+    renderWeekdayPicker();
+    try {
+        const res = await apiFetch('/api/display/playlist');
+        if (!res.ok) return;
+        playlist = await res.json();
+
+        const tbody = document.getElementById('playlist-list');
+        tbody.innerHTML = '';
+        playlist.forEach((item, i) => {
+            const tr = document.createElement('tr');
+            tr.innerHTML = `
+                <td><strong>${playlistKindNames[item.kind] || item.kind}</strong> <span class="playlist-title"></span></td>
+                <td>${item.duration > 0 ? item.duration + ' dtk' : 'Sampai selesai'}</td>
+                <td>${describeSchedule(item)}</td>
+                <td>
+                    <button class="btn btn-secondary" onclick="movePlaylistItem(${i}, -1)" ${i === 0 ? 'disabled' : ''}>&uarr;</button>
+                    <button class="btn btn-secondary" onclick="movePlaylistItem(${i}, 1)" ${i === playlist.length - 1 ? 'disabled' : ''}>&darr;</button>
+                    <button class="btn btn-secondary" onclick="togglePlaylistItem(${item.id})">${item.enabled ? 'Nonaktifkan' : 'Aktifkan'}</button>
+                    <button class="btn btn-danger" onclick="deletePlaylistItem(${item.id})">Hapus</button>
+                </td>
+            `;
+            tr.querySelector('.playlist-title').textContent = item.title || item.media_url;
+            tbody.appendChild(tr);
+        });
+    } catch (err) {
+        console.error('Error loading playlist:', err);
+    }
+}
+
+async function createPlaylistItem() {
+    const weekdays = [...document.querySelectorAll('#playlist-weekdays input:checked')].map(el => parseInt(el.value));
+    const item = {
+        kind: document.getElementById('playlist-kind').value,
+        title: document.getElementById('playlist-title').value.trim(),
+        body: document.getElementById('playlist-body').value.trim(),
+        media_url: document.getElementById('playlist-media-url').value.trim(),
+        duration: parseInt(document.getElementById('playlist-duration').value) || 0,
+        starts_on: document.getElementById('playlist-starts-on').value,
+        ends_on: document.getElementById('playlist-ends-on').value,
+        daily_start: document.getElementById('playlist-daily-start').value,
+        daily_end: document.getElementById('playlist-daily-end').value,
+        weekdays: weekdays,
+        enabled: true
+    };
+
+    try {
+        const res = await apiFetch('/api/display/playlist', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify(item)
+        });
+        if (!res.ok) {
+            alert('Gagal menambah konten: ' + await res.text());
+            return;
+        }
+
+        ['playlist-title', 'playlist-body', 'playlist-media-url'].forEach(id => {
+            document.getElementById(id).value = '';
+        });
+        loadPlaylist();
+    } catch (err) {
+        console.error('Error creating playlist item:', err);
+    }
+}
+
+async function togglePlaylistItem(id) {
+    const item = playlist.find(i => i.id === id);
+    if (!item) return;
+
+    try {
+        const res = await apiFetch(`/api/display/playlist/${id}`, {
+            method: 'PUT',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ ...item, enabled: !item.enabled })
+        });
+        if (!res.ok) throw new Error('Failed to update playlist item');
+        loadPlaylist();
+    } catch (err) {
+        console.error('Error updating playlist item:', err);
+        alert('Gagal mengubah konten');
+    }
+}
+
+async function movePlaylistItem(index, step) {
+    const ids = playlist.map(i => i.id);
+    const target = index + step;
+    if (target < 0 || target >= ids.length) return;
+    [ids[index], ids[target]] = [ids[target], ids[index]];
+
+    try {
+        const res = await apiFetch('/api/display/playlist/order', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ ids: ids })
+        });
+        if (!res.ok) throw new Error('Failed to reorder playlist');
+        loadPlaylist();
+    } catch (err) {
+        console.error('Error reordering playlist:', err);
+        alert('Gagal mengubah urutan');
+    }
+}
+
+async function deletePlaylistItem(id) {
+    if (!confirm('Hapus konten ini dari playlist?')) return;
+
+    try {
+        const res = await apiFetch(`/api/display/playlist/${id}`, { method: 'DELETE' });
+        if (!res.ok) throw new Error('Failed to delete playlist item');
+        loadPlaylist();
+    } catch (err) {
+        console.error('Error deleting playlist item:', err);
+        alert('Gagal menghapus konten');
+    }
+}
+
+// =====================
+// ANNOUNCEMENTS
+// =====================
+
+const announcementPriorityNames = {
+    normal: 'Normal',
+    high: 'Tinggi',
+    urgent: 'Mendesak'
+};
+
+// Paired displays that announcements can be limited to, by ID
+let announcementDisplays = {};
+
+async function loadAnnouncementDisplays() {
+    try {
+        const res = await apiFetch('/api/devices');
+        if (!res.ok) return;
+        const devices = await res.json();
+
+        announcementDisplays = {};
+        devices.filter(d => d.role === 'display' && d.status === 'active')
+            .forEach(d => { announcementDisplays[d.id] = d.name; });
+
+        const container = document.getElementById('announcement-devices');
+        container.innerHTML = '';
+        Object.entries(announcementDisplays).forEach(([id, name]) => {
+            const label = document.createElement('label');
+            label.innerHTML = `<input type="checkbox" value="${id}"> <span></span>`;
+            label.querySelector('span').textContent = name;
+            container.appendChild(label);
+        });
+    } catch (err) {
+        console.error('Error loading displays:', err);
+    }
+}
+
+function formatDateTime(value) {
+    return value ? new Date(value).toLocaleString('id-ID', { dateStyle: 'short', timeStyle: 'short' }) : '...';
+}
+
+async function loadAnnouncements() {
+    await loadAnnouncementDisplays();
+    try {
+        const res = await apiFetch('/api/announcements');
+        if (!res.ok) return;
+        const announcements = await res.json();
+
+        const tbody = document.getElementById('announcements-list');
+        tbody.innerHTML = '';
+        announcements.forEach(a => {
+            const targets = a.device_ids.length > 0
+                ? a.device_ids.map(id => announcementDisplays[id] || `#${id}`).join(', ')
+                : 'Semua';
+            const tr = document.createElement('tr');
+            tr.innerHTML = `
+                <td class="announcement-text"></td>
+                <td>${announcementPriorityNames[a.priority] || a.priority}</td>
+                <td>${formatDateTime(a.starts_at)} s.d. ${formatDateTime(a.ends_at)}</td>
+                <td class="announcement-targets"></td>
+                <td><button class="btn btn-danger" onclick="deleteAnnouncement(${a.id})">Hapus</button></td>
+            `;
+            tr.querySelector('.announcement-text').textContent = a.text;
+            tr.querySelector('.announcement-targets').textContent = targets;
+            tbody.appendChild(tr);
+        });
+    } catch (err) {
+        console.error('Error loading announcements:', err);
+    }
+}
+
+async function createAnnouncement() {
+    const startsAt = document.getElementById('announcement-starts-at').value;
+    const endsAt = document.getElementById('announcement-ends-at').value;
+    const announcement = {
+        text: document.getElementById('announcement-text').value.trim(),
+        priority: document.getElementById('announcement-priority').value,
+        starts_at: startsAt ? new Date(startsAt).toISOString() : null,
+        ends_at: endsAt ? new Date(endsAt).toISOString() : null,
+        device_ids: [...document.querySelectorAll('#announcement-devices input:checked')].map(el => parseInt(el.value))
+    };
+
+    try {
+        const res = await apiFetch('/api/announcements', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify(announcement)
+        });
+        if (!res.ok) {
+            alert('Gagal menambah pengumuman: ' + await res.text());
+            return;
+        }
+
+        ['announcement-text', 'announcement-starts-at', 'announcement-ends-at'].forEach(id => {
+            document.getElementById(id).value = '';
+        });
+        loadAnnouncements();
+    } catch (err) {
+        console.error('Error creating announcement:', err);
+    }
+}
+
+async function deleteAnnouncement(id) {
+    if (!confirm('Hapus pengumuman ini?')) return;
+
+    try {
+        const res = await apiFetch(`/api/announcements/${id}`, { method: 'DELETE' });
+        if (!res.ok) throw new Error('Failed to delete announcement');
+        loadAnnouncements();
+    } catch (err) {
+        console.error('Error deleting announcement:', err);
+        alert('Gagal menghapus pengumuman');
+    }
+}
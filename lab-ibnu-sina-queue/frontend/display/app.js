@@ -8,7 +8,14 @@ setInterval(() => {
 // State and History
 const state = {
     current: null,
-    history: []
+    history: [],
+    muted: false,
+    announcements: {},
+    board: {},
+    // Resolves when the announcement being spoken ends
+    speaking: Promise.resolve(),
+    // How far our clock is ahead of the server's, see trackServerClock
+    clockOffset: null
 };
 
 // Global AudioContext
@@ -44,74 +51,319 @@ function unlockAudio() {
     }
 }
 
-// Initial Fetch
-fetch('/api/queue/recent')
-    .then(res => res.json())
-    .then(data => {
-        if (Array.isArray(data)) {
-            data.reverse().forEach(t => addToHistory(t));
-        }
-    })
-    .catch(console.error);
+// Send unpaired screens to the login page; the initial state arrives as a
+// SNAPSHOT once the WebSocket connects
+currentUser().catch(console.error);
+// WebSocket
+const STALE_ANNOUNCE_MS = 60000;
 
-// Initial Video Settings
-fetch('/api/display/video')
-    .then(res => res.json())
-    .then(data => {
-        updateVideoDisplay(data);
-    })
-    .catch(console.error);
+// A display can be limited to some categories or counters,
+// e.g. /display/?topics=counter:1,counter:2
+const displayTopics = (new URLSearchParams(window.location.search).get('topics') || '')
+    .split(',').map(t => t.trim()).filter(Boolean);
 
-// WebSocket
 const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
 const ws = new QueueWebSocket(`${protocol}//${window.location.host}/ws`, (message) => {
     console.log('Received:', message);
+    trackServerClock(message);
+
+    if (handleDeviceMessage(message, applyDeviceSettings)) return;
+    if (handleEmergencyMessage(message)) return;
 
-    if (message.type === 'NEW_TICKET') {
+    if (message.type === 'SNAPSHOT') {
+        applySnapshot(message.data);
+    } else if (message.type === 'NEW_TICKET') {
         const ticket = message.data;
         addToHistory(ticket);
     } else if (message.type === 'CALL_TICKET') {
         handleCall(message.data);
+    } else if (message.type === 'RECALL_TICKET') {
+        handleRecall(message.data);
+    } else if (message.type === 'ANNOUNCE') {
+        scheduleAnnounce(message);
+    } else if (message.type === 'TICKETS_VOIDED') {
+        removeFromHistory(message.data.map(t => t.id));
     } else if (message.type === 'RESET_QUEUE') {
         document.getElementById('current-number').textContent = '--';
         document.getElementById('current-counter').textContent = 'LOKET --';
         document.getElementById('history-list').innerHTML = '';
+        Object.values(state.board).forEach(entry => {
+            entry.status = 'idle';
+            entry.ticket = null;
+        });
+        renderBoard();
+    } else if (message.type === 'BOARD_UPDATE') {
+        state.board[message.data.counter] = message.data;
+        renderBoard();
     } else if (message.type === 'UPDATE_VIDEO') {
         updateVideoDisplay(message.data);
+    } else if (message.type === 'UPDATE_PLAYLIST') {
+        setPlaylist(message.data);
+    } else if (message.type === 'ANNOUNCEMENT') {
+        state.announcements[message.data.id] = message.data;
+        renderAnnouncements();
+    } else if (message.type === 'ANNOUNCEMENT_REMOVED') {
+        delete state.announcements[message.data.id];
+        renderAnnouncements();
+    }
+}, null, displayTopics);
+ws.register(deviceRegistration());
+
+// Layout and mute are set remotely from the admin panel
+function applyDeviceSettings(settings) {
+    if (settings.layout) {
+        document.body.classList.remove('layout-calls-only', 'layout-mirrored', 'layout-board');
+        if (settings.layout !== 'standard') document.body.classList.add(`layout-${settings.layout}`);
+    }
+    if (typeof settings.muted === 'boolean') {
+        state.muted = settings.muted;
+        if (state.muted && 'speechSynthesis' in window) window.speechSynthesis.cancel();
     }
-});
+}
 
+// The single video of the display settings, shown when no playlist item
+// is scheduled
 function updateVideoDisplay(data) {
+    player.fallback = data;
+    if (player.current === 'fallback') showFallback();
+}
+
+function showFallback() {
+    const data = player.fallback;
+    if (!data) return;
+    setOverlay(data.title, data.subtitle);
+    if (data.video_url) {
+        showVideo(data.video_url, true);
+    } else {
+        // Back to the placeholder background
+        const content = document.querySelector('.media-content');
+        content.innerHTML = '';
+        content.style.backgroundImage = '';
+    }
+}
+
+function setOverlay(title, subtitle) {
     const overlay = document.querySelector('.media-overlay');
+    if (!overlay) return;
+    overlay.style.display = title || subtitle ? '' : 'none';
+    const titleEl = overlay.querySelector('h3');
+    const subtitleEl = overlay.querySelector('p');
+    if (titleEl) titleEl.textContent = title || '';
+    if (subtitleEl) subtitleEl.textContent = subtitle || '';
+}
+
+// showVideo plays a YouTube or direct video URL; onEnded is called when a
+// non-looping direct video finishes
+function showVideo(url, loop, onEnded) {
     const content = document.querySelector('.media-content');
+    if (!content) return;
 
-    // Update Text
-    if (overlay && data) {
-        const titleEl = overlay.querySelector('h3');
-        const subtitleEl = overlay.querySelector('p');
-        if (titleEl && data.title) titleEl.textContent = data.title;
-        if (subtitleEl && data.subtitle) subtitleEl.textContent = data.subtitle;
+    // Robust YouTube ID extraction
+    const ytRegex = /^.*((youtu.be\/)|(v\/)|(\/u\/\w\/)|(embed\/)|(watch\?))\??v?=?([^#&?]*).*/;
+    const match = url.match(ytRegex);
+    const videoId = (match && match[7].length === 11) ? match[7] : null;
+
+    // Remove placeholder background
+    content.style.backgroundImage = 'none';
+
+    if (videoId) {
+        // Autoplay=1, Mute=1 (required), Loop=1, Playlist=videoId, Controls=1
+        const embedUrl = `https://www.youtube.com/embed/${videoId}?autoplay=1&mute=1&loop=1&playlist=${videoId}&controls=1&rel=0&showinfo=0&enablejsapi=1`;
+        content.innerHTML = `<iframe width="100%" height="100%" src="${embedUrl}" frameborder="0" allow="accelerometer; autoplay; clipboard-write; encrypted-media; gyroscope; picture-in-picture" allowfullscreen></iframe>`;
+    } else {
+        // Direct video file (muted required for reliable autoplay)
+        content.innerHTML = `<video width="100%" height="100%" autoplay ${loop ? 'loop' : ''} muted controls playsinline style="object-fit: cover;"></video>`;
+        const video = content.querySelector('video');
+        video.src = url;
+        if (onEnded) video.addEventListener('ended', onEnded);
     }
+}
+
+// ==========================================
+// PLAYLIST
+// ==========================================
 
-    // Update Video
-    if (content && data.video_url) {
-        // Robust YouTube ID extraction
-        const ytRegex = /^.*((youtu.be\/)|(v\/)|(\/u\/\w\/)|(embed\/)|(watch\?))\??v?=?([^#&?]*).*/;
-        const match = data.video_url.match(ytRegex);
-        const videoId = (match && match[7].length === 11) ? match[7] : null;
-
-        // Remove placeholder background
-        content.style.backgroundImage = 'none';
-
-        if (videoId) {
-            // Autoplay=1, Mute=1 (required), Loop=1, Playlist=videoId, Controls=1
-            const embedUrl = `https://www.youtube.com/embed/${videoId}?autoplay=1&mute=1&loop=1&playlist=${videoId}&controls=1&rel=0&showinfo=0&enablejsapi=1`;
-            content.innerHTML = `<iframe width="100%" height="100%" src="${embedUrl}" frameborder="0" allow="accelerometer; autoplay; clipboard-write; encrypted-media; gyroscope; picture-in-picture" allowfullscreen></iframe>`;
-        } else {
-            // Direct video file (muted required for reliable autoplay)
-            content.innerHTML = `<video width="100%" height="100%" src="${data.video_url}" autoplay loop muted controls playsinline style="object-fit: cover;"></video>`;
+const player = {
+    items: [],
+    fallback: null,
+    current: null,
+    index: -1,
+    timer: null
+};
+
+// Schedules are checked again at least this often, so items start and stop
+// on time even while one item loops
+const PLAYLIST_RECHECK_MS = 60000;
+
+// Videos playing to the end move on after this at the latest, since the end
+// of YouTube embeds is not reported
+const PLAYLIST_MAX_VIDEO_MS = 15 * 60000;
+
+function setPlaylist(items) {
+    player.items = items || [];
+    player.index = -1;
+    player.current = null;
+    playNext();
+}
+
+// scheduledNow reports whether an item may play at the display's local time
+function scheduledNow(item, now) {
+    if (!item.enabled) return false;
+
+    const pad = (n) => String(n).padStart(2, '0');
+    const today = `${now.getFullYear()}-${pad(now.getMonth() + 1)}-${pad(now.getDate())}`;
+    if (item.starts_on && today < item.starts_on) return false;
+    if (item.ends_on && today > item.ends_on) return false;
+
+    const weekday = now.getDay() || 7;
+    if (item.weekdays && item.weekdays.length > 0 && !item.weekdays.includes(weekday)) return false;
+
+    if (item.daily_start && item.daily_end) {
+        const clock = `${pad(now.getHours())}:${pad(now.getMinutes())}`;
+        if (item.daily_start <= item.daily_end) {
+            if (clock < item.daily_start || clock >= item.daily_end) return false;
+        } else if (clock < item.daily_start && clock >= item.daily_end) {
+            // Windows like 22:00-06:00 run past midnight
+            return false;
+        }
+    }
+    return true;
+}
+
+function playNext() {
+    clearTimeout(player.timer);
+
+    const playable = player.items.filter(item => scheduledNow(item, new Date()));
+    if (playable.length === 0) {
+        if (player.current !== 'fallback') {
+            player.current = 'fallback';
+            showFallback();
         }
+        player.timer = setTimeout(playNext, PLAYLIST_RECHECK_MS);
+        return;
+    }
+
+    player.index = (player.index + 1) % playable.length;
+    const item = playable[player.index];
+
+    // A lone item keeps playing instead of restarting
+    if (playable.length === 1 && player.current === item.id) {
+        player.timer = setTimeout(playNext, PLAYLIST_RECHECK_MS);
+        return;
+    }
+    player.current = item.id;
+    showItem(item, playable.length === 1);
+
+    if (item.duration > 0) {
+        player.timer = setTimeout(playNext, item.duration * 1000);
+    } else if (playable.length === 1) {
+        player.timer = setTimeout(playNext, PLAYLIST_RECHECK_MS);
+    } else {
+        player.timer = setTimeout(playNext, PLAYLIST_MAX_VIDEO_MS);
+    }
+}
+
+function showItem(item, alone) {
+    const content = document.querySelector('.media-content');
+    content.style.backgroundImage = 'none';
+
+    if (item.kind === 'video') {
+        setOverlay(item.title, item.body);
+        // Videos without a duration play to the end, or loop when alone
+        showVideo(item.media_url, alone || item.duration > 0, item.duration > 0 ? null : playNext);
+    } else if (item.kind === 'image') {
+        setOverlay(item.title, item.body);
+        content.innerHTML = '<img style="width: 100%; height: 100%; object-fit: cover;">';
+        content.querySelector('img').src = item.media_url;
+    } else {
+        setOverlay('', '');
+        content.innerHTML = '<div class="text-slide"><h2></h2><p></p></div>';
+        content.querySelector('h2').textContent = item.title;
+        content.querySelector('p').textContent = item.body;
+    }
+}
+
+function applySnapshot(snapshot) {
+    const list = document.getElementById('history-list');
+    list.innerHTML = '';
+    (snapshot.recent || []).slice().reverse().forEach(t => addToHistory(t));
+
+    // The most recent call of any counter takes the main card
+    const calling = (snapshot.counters || []).slice()
+        .sort((a, b) => new Date(b.called_at || 0) - new Date(a.called_at || 0));
+    state.current = calling[0] || null;
+    setRecallStyle(false);
+    if (state.current) {
+        updateMainDisplay(state.current);
+    } else {
+        document.getElementById('current-number').textContent = '--';
+        document.getElementById('current-counter').textContent = 'LOKET --';
     }
+
+    state.board = {};
+    (snapshot.board || []).forEach(entry => { state.board[entry.counter] = entry; });
+    renderBoard();
+
+    if (snapshot.display) player.fallback = snapshot.display;
+    setPlaylist(snapshot.playlist);
+
+    state.announcements = {};
+    (snapshot.announcements || []).forEach(a => { state.announcements[a.id] = a; });
+    renderAnnouncements();
+
+    showEmergency(snapshot.emergency);
+}
+
+// Board layout: the ticket of every counter at once
+const boardStatusNames = {
+    calling: 'DIPANGGIL',
+    serving: 'DILAYANI',
+    idle: 'KOSONG'
+};
+
+function renderBoard() {
+    const container = document.getElementById('counter-board');
+    container.innerHTML = '';
+    Object.values(state.board)
+        .sort((a, b) => a.counter - b.counter)
+        .forEach(entry => {
+            const tile = document.createElement('div');
+            tile.className = `board-tile ${entry.status}`;
+            tile.innerHTML = `
+                <div class="board-counter">LOKET ${entry.counter}</div>
+                <div class="board-number">${entry.ticket ? entry.ticket.formatted_code : '--'}</div>
+                <div class="board-status">${boardStatusNames[entry.status] || entry.status}</div>
+            `;
+            container.appendChild(tile);
+        });
+}
+
+// Announcements
+const ANNOUNCEMENT_ORDER = { urgent: 0, high: 1, normal: 2 };
+const defaultTicker = document.querySelector('.ticker-content').textContent.trim();
+
+// renderAnnouncements puts the active announcements in the running text,
+// most important first, and urgent ones in the banner too
+function renderAnnouncements() {
+    const now = Date.now();
+    const active = Object.values(state.announcements)
+        .filter(a => !a.ends_at || new Date(a.ends_at).getTime() > now)
+        .sort((a, b) => ANNOUNCEMENT_ORDER[a.priority] - ANNOUNCEMENT_ORDER[b.priority] || a.id - b.id);
+
+    const ticker = document.querySelector('.ticker-content');
+    const text = active.length > 0 ? active.map(a => a.text).join('  \u2022  ') : defaultTicker;
+    if (ticker.textContent !== text) {
+        ticker.textContent = text;
+        // Restart the marquee so the new text starts from the right
+        ticker.style.animation = 'none';
+        void ticker.offsetWidth;
+        ticker.style.animation = '';
+    }
+
+    const urgent = active.filter(a => a.priority === 'urgent');
+    const banner = document.getElementById('announcement-banner');
+    banner.textContent = urgent.map(a => a.text).join('  \u2022  ');
+    banner.hidden = urgent.length === 0;
 }
 
 function handleCall(ticket) {
@@ -119,8 +371,29 @@ function handleCall(ticket) {
         addToHistory(state.current);
     }
     state.current = ticket;
+    setRecallStyle(false);
+    updateMainDisplay(ticket);
+}
+
+function handleRecall(ticket) {
+    // A recall of another counter's ticket still takes over the main card
+    if (state.current && state.current.id !== ticket.id) {
+        addToHistory(state.current);
+    }
+    state.current = ticket;
+    setRecallStyle(true);
     updateMainDisplay(ticket);
-    announce(ticket);
+}
+
+function setRecallStyle(isRecall) {
+    const card = document.querySelector('.now-serving-card');
+    card.classList.remove('recall');
+    if (isRecall) {
+        // Restart the flash animation on repeated recalls
+        void card.offsetWidth;
+        card.classList.add('recall');
+    }
+    document.getElementById('serving-label').textContent = isRecall ? 'PANGGILAN ULANG' : 'ANTRIAN SAAT INI';
 }
 
 function updateMainDisplay(ticket) {
@@ -138,6 +411,7 @@ function addToHistory(ticket) {
     const list = document.getElementById('history-list');
     const div = document.createElement('div');
     div.className = 'history-item';
+    div.dataset.id = ticket.id;
     div.innerHTML = `
         <div class="history-col">
             <span class="label-sm">Nomor</span>
@@ -153,6 +427,13 @@ function addToHistory(ticket) {
     if (list.children.length > 4) list.lastElementChild.remove();
 }
 
+// removeFromHistory drops voided tickets from the history list
+function removeFromHistory(ids) {
+    document.querySelectorAll('#history-list .history-item').forEach(item => {
+        if (ids.includes(Number(item.dataset.id))) item.remove();
+    });
+}
+
 // ==========================================
 // SIMPLE VOICE ANNOUNCEMENT (Local/Native)
 // ==========================================
@@ -193,7 +474,37 @@ function ticketToSpeech(formattedCode) {
     return `${letterWord} ${numberWords}`;
 }
 
+// scheduleAnnounce speaks an ANNOUNCE event when it is due. The server
+// spaces calls of every counter, so all displays speak them in the same
+// order without overlapping. The delay is taken from the server's own
+// times, so a display with a wrong clock still keeps the spacing.
+function scheduleAnnounce(message) {
+    // Calls replayed after a reconnect are shown but no longer announced
+    const sent = new Date(message.time).getTime();
+    if (!message.time || Date.now() - state.clockOffset - sent > STALE_ANNOUNCE_MS) return;
+
+    const delay = Math.max(0, new Date(message.data.play_at).getTime() - sent);
+    setTimeout(() => {
+        // Wait for a slow speech synthesis rather than talk over it
+        state.speaking = state.speaking.then(() => announce(message.data)).catch(console.error);
+    }, delay);
+}
+
+// trackServerClock estimates how far the display's clock is off from the
+// server's, so a TV with a wrong clock does not take every call for stale.
+// Events arrive at the earliest right after they are sent, so the smallest
+// difference seen is the closest estimate; replayed events only make it
+// larger.
+function trackServerClock(message) {
+    if (!message.time) return;
+    const offset = Date.now() - new Date(message.time).getTime();
+    if (state.clockOffset === null || offset < state.clockOffset) state.clockOffset = offset;
+}
+
+// announce speaks a call, resolving when it ends
 function announce(ticket) {
+    if (state.muted || emergencyShown()) return Promise.resolve();
+
     const ticketSpeech = ticketToSpeech(ticket.formatted_code);
     const counterNum = ticket.counter || 1;
     const counterSpeech = counterToWords(counterNum);
@@ -203,20 +514,49 @@ function announce(ticket) {
 
     console.log('Announcing:', text);
 
-    // Play bell first
-    playChime().then(() => {
-        speakLocal(text);
+    // Play bell first, then the server's recorded voice when it has one
+    return playChime().then(() => {
+        if (!ticket.audio_url) return speakLocal(text);
+        return playVoice(ticket.audio_url).catch(err => {
+            console.error('Voice playback failed, using speech synthesis:', err);
+            return speakLocal(text);
+        });
+    });
+}
+
+// playVoice plays an announcement assembled by the server from recorded
+// clips, resolving when it ends
+async function playVoice(url) {
+    const ctx = initAudioContext();
+    if (ctx.state === 'suspended') await ctx.resume();
+
+    const res = await fetch(url, { credentials: 'same-origin' });
+    if (!res.ok) throw new Error(`HTTP ${res.status}`);
+    const buffer = await ctx.decodeAudioData(await res.arrayBuffer());
+
+    return new Promise(resolve => {
+        const source = ctx.createBufferSource();
+        source.buffer = buffer;
+        source.connect(ctx.destination);
+        source.onended = resolve;
+        source.start();
     });
 }
 
 function speakLocal(text) {
-    if ('speechSynthesis' in window) {
+    return new Promise((resolve) => {
+        if (!('speechSynthesis' in window)) {
+            resolve();
+            return;
+        }
         // Native browser TTS logic
         const utterance = new SpeechSynthesisUtterance(text);
         utterance.lang = 'id-ID';
         utterance.rate = 0.9;
+        utterance.onend = resolve;
+        utterance.onerror = resolve;
         window.speechSynthesis.speak(utterance);
-    }
+    });
 }
 
 function playChime() {
@@ -273,3 +613,9 @@ document.addEventListener('keydown', unlockAudio, { once: true });
 setTimeout(() => {
     unlockAudio();
 }, 100);
+
+// Calls are not announced over the emergency override
+function emergencyShown() {
+    const overlay = document.getElementById('emergency-overlay');
+    return overlay && overlay.style.display !== 'none';
+}
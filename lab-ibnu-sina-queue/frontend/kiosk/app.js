@@ -10,6 +10,18 @@ function updateTime() {
 setInterval(updateTime, 1000);
 updateTime();
 
+// Make sure the kiosk is logged in before patients use it
+currentUser().catch(console.error);
+
+// Stay reachable for remote reload and identify from the admin panel, and
+// for the emergency override, which is checked again after every reconnect
+const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
+const ws = new QueueWebSocket(`${protocol}//${window.location.host}/ws`, (message) => {
+    if (handleDeviceMessage(message)) return;
+    handleEmergencyMessage(message);
+}, loadEmergency);
+ws.register(deviceRegistration());
+
 // Category Mapping
 const categories = {
     1: { name: 'Pemeriksaan Lab', prefix: 'A' },
@@ -24,11 +36,19 @@ async function selectService(categoryId) {
 
     try {
         // Send request to backend
-        const response = await fetch('/api/queue/create', {
-            method: 'POST',
-            headers: { 'Content-Type': 'application/json' },
-            body: JSON.stringify({ category_id: categoryId })
-        });
+        const response = await createTicketWithRetry(categoryId);
+
+        if (response.status === 429) {
+            const info = await response.json();
+            showCooldown(info.retry_after);
+            return;
+        }
+
+        // Emergency override started before this kiosk heard of it
+        if (response.status === 503) {
+            loadEmergency();
+            return;
+        }
 
         if (!response.ok) throw new Error('Network response was not ok');
 
@@ -41,6 +61,25 @@ async function selectService(categoryId) {
     }
 }
 
+// Retry network failures with the same Idempotency-Key so a blip never
+// prints two tickets for one tap
+async function createTicketWithRetry(categoryId, attempts = 3) {
+    const key = newIdempotencyKey();
+
+    for (let i = 1; ; i++) {
+        try {
+            return await apiFetch('/api/queue/create', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json', 'Idempotency-Key': key },
+                body: JSON.stringify({ category_id: categoryId })
+            });
+        } catch (err) {
+            if (i >= attempts || err.message === 'Unauthorized') throw err;
+            await new Promise(resolve => setTimeout(resolve, 1000 * i));
+        }
+    }
+}
+
 // Update Print Area with ticket data
 function updatePrintArea(ticket) {
     const pNumber = document.getElementById('p-number');
@@ -96,3 +135,25 @@ function closeModal() {
     const modal = document.getElementById('ticket-modal');
     modal.classList.remove('open');
 }
+
+// Cooldown after too many tickets in a short time
+let cooldownTimer = null;
+
+function showCooldown(seconds) {
+    const modal = document.getElementById('cooldown-modal');
+    const timerEl = document.getElementById('cooldown-timer');
+    let remaining = seconds || 30;
+
+    clearInterval(cooldownTimer);
+    timerEl.textContent = remaining;
+    modal.classList.add('open');
+
+    cooldownTimer = setInterval(() => {
+        remaining--;
+        timerEl.textContent = remaining;
+        if (remaining <= 0) {
+            clearInterval(cooldownTimer);
+            modal.classList.remove('open');
+        }
+    }, 1000);
+}
@@ -0,0 +1,66 @@
+// Where to go after logging in
+const params = new URLSearchParams(window.location.search);
+const next = params.get('next') && params.get('next').startsWith('/') ? params.get('next') : '/admin/';
+
+document.getElementById('login-form').addEventListener('submit', async (e) => {
+    e.preventDefault();
+    const errorEl = document.getElementById('login-error');
+    errorEl.textContent = '';
+
+    try {
+        const res = await fetch('/api/auth/login', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            credentials: 'same-origin',
+            body: JSON.stringify({
+                username: document.getElementById('username').value.trim(),
+                password: document.getElementById('password').value
+            })
+        });
+
+        if (res.status === 401) {
+            errorEl.textContent = 'Username atau password salah';
+            return;
+        }
+        if (!res.ok) throw new Error('Login failed');
+
+        window.location.href = next;
+    } catch (err) {
+        console.error('Error logging in:', err);
+        errorEl.textContent = 'Sistem tidak dapat dihubungi';
+    }
+});
+
+document.getElementById('pair-form').addEventListener('submit', async (e) => {
+    e.preventDefault();
+    const errorEl = document.getElementById('pair-error');
+    errorEl.textContent = '';
+
+    try {
+        const res = await fetch('/api/devices/pair', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            credentials: 'same-origin',
+            body: JSON.stringify({
+                code: document.getElementById('pairing-code').value.trim().toUpperCase()
+            })
+        });
+
+        if (res.status === 401) {
+            errorEl.textContent = 'Kode tidak valid atau sudah kedaluwarsa';
+            return;
+        }
+        if (res.status === 429) {
+            errorEl.textContent = 'Terlalu banyak percobaan, coba lagi nanti';
+            return;
+        }
+        if (!res.ok) throw new Error('Pairing failed');
+
+        // The device key is kept in a cookie, so the device role's page opens directly
+        const { device } = await res.json();
+        window.location.href = params.get('next') ? next : (device.role === 'kiosk' ? '/kiosk/' : '/display/');
+    } catch (err) {
+        console.error('Error pairing device:', err);
+        errorEl.textContent = 'Sistem tidak dapat dihubungi';
+    }
+});
@@ -0,0 +1,56 @@
+// Fetch wrapper that sends the user to the login page when the session is
+// missing or expired. Login state lives in an HttpOnly cookie.
+async function apiFetch(url, options = {}) {
+    const res = await fetch(url, { credentials: 'same-origin', ...options });
+    if (res.status === 401) {
+        redirectToLogin();
+        throw new Error('Unauthorized');
+    }
+    return res;
+}
+
+function redirectToLogin() {
+    const next = encodeURIComponent(window.location.pathname + window.location.search);
+    window.location.href = `/login/?next=${next}`;
+}
+
+async function currentUser() {
+    const res = await apiFetch('/api/auth/me');
+    return res.json();
+}
+
+async function logout() {
+    await fetch('/api/auth/logout', { method: 'POST', credentials: 'same-origin' });
+    redirectToLogin();
+}
+
+// Idempotency-Key for one user action. Reuse the same key when retrying so
+// the server returns the original result instead of acting twice.
+function newIdempotencyKey() {
+    if (window.crypto && crypto.randomUUID) return crypto.randomUUID();
+    return Date.now().toString(36) + Math.random().toString(36).slice(2);
+}
+
+// Repeating the same action shortly after (a double-click) reuses its key,
+// so only one request takes effect
+const recentActionKeys = {};
+
+function actionIdempotencyKey(action, windowMs = 3000) {
+    const now = Date.now();
+    const recent = recentActionKeys[action];
+    if (recent && now - recent.at < windowMs) return recent.key;
+
+    const key = newIdempotencyKey();
+    recentActionKeys[action] = { key: key, at: now };
+    return key;
+}
+
+// POST a JSON action with an Idempotency-Key derived from the action itself
+function postAction(url, payload) {
+    const body = JSON.stringify(payload || {});
+    return apiFetch(url, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json', 'Idempotency-Key': actionIdempotencyKey(url + body) },
+        body: body
+    });
+}
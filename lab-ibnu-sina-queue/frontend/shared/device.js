@@ -0,0 +1,59 @@
+// Remote control of paired displays and kiosks, from the Perangkat page of
+// the admin panel
+const APP_VERSION = '1.5.0';
+
+// deviceRegistration is what the device reports when it connects. The
+// location can be set per screen, e.g. /display/?location=Lobby%20Lantai%201
+function deviceRegistration() {
+    const params = new URLSearchParams(window.location.search);
+    return {
+        location: params.get('location') || localStorage.getItem('deviceLocation') || '',
+        version: APP_VERSION
+    };
+}
+
+// handleDeviceMessage applies REGISTERED and DEVICE_COMMAND messages and
+// reports whether the message was one of them. apply receives the layout
+// and mute settings.
+function handleDeviceMessage(message, apply) {
+    if (message.type === 'REGISTERED') {
+        if (message.data && apply) apply(message.data);
+        return true;
+    }
+    if (message.type === 'DEVICE_REVOKED') {
+        // The key no longer works; reloading leads to the pairing page
+        window.location.reload();
+        return true;
+    }
+    if (message.type !== 'DEVICE_COMMAND') return false;
+
+    const cmd = message.data;
+    // Commands replayed after a reconnect are stale
+    if (message.time && Date.now() - new Date(message.time).getTime() > 60000) return true;
+
+    if (cmd.command === 'reload') {
+        window.location.reload();
+    } else if (cmd.command === 'identify') {
+        identifyDevice(cmd.name);
+    } else if (apply) {
+        apply(cmd);
+    }
+    return true;
+}
+
+// identifyDevice shows the device name full screen for a few seconds so
+// staff can tell which screen is which
+function identifyDevice(name) {
+    let overlay = document.getElementById('identify-overlay');
+    if (!overlay) {
+        overlay = document.createElement('div');
+        overlay.id = 'identify-overlay';
+        overlay.style.cssText = 'position:fixed;inset:0;z-index:9999;display:flex;align-items:center;' +
+            'justify-content:center;background:rgba(15,23,42,0.9);color:#fff;font-size:6vw;font-weight:800;text-align:center;padding:5vw;';
+        document.body.appendChild(overlay);
+    }
+    overlay.textContent = name;
+    overlay.style.display = 'flex';
+    clearTimeout(identifyDevice.timer);
+    identifyDevice.timer = setTimeout(() => { overlay.style.display = 'none'; }, 10000);
+}
@@ -0,0 +1,44 @@
+// Full-screen emergency override of displays and kiosks, started and
+// cleared by a supervisor from the admin panel
+
+// showEmergency shows or hides the override for an emergency state
+function showEmergency(emergency) {
+    let overlay = document.getElementById('emergency-overlay');
+    if (!emergency || !emergency.active) {
+        if (overlay) overlay.style.display = 'none';
+        return;
+    }
+
+    if (!overlay) {
+        overlay = document.createElement('div');
+        overlay.id = 'emergency-overlay';
+        overlay.style.cssText = 'position:fixed;inset:0;z-index:10000;display:flex;flex-direction:column;align-items:center;' +
+            'justify-content:center;gap:3vh;background:#b91c1c;color:#fff;text-align:center;padding:5vw;';
+        overlay.innerHTML = '<div style="font-size:4vw;font-weight:800;letter-spacing:0.1em;">PERHATIAN</div>' +
+            '<div class="emergency-message" style="font-size:5vw;font-weight:700;line-height:1.2;"></div>' +
+            '<div style="font-size:2vw;opacity:0.8;">Pengambilan antrian dihentikan sementara. Ikuti arahan petugas.</div>';
+        document.body.appendChild(overlay);
+    }
+    overlay.querySelector('.emergency-message').textContent = emergency.message;
+    overlay.style.display = 'flex';
+    if ('speechSynthesis' in window) window.speechSynthesis.cancel();
+}
+
+// handleEmergencyMessage applies EMERGENCY messages and reports whether
+// the message was one
+function handleEmergencyMessage(message) {
+    if (message.type !== 'EMERGENCY') return false;
+    showEmergency(message.data);
+    return true;
+}
+
+// loadEmergency fetches the current state, for clients that get no
+// SNAPSHOT such as kiosks
+async function loadEmergency() {
+    try {
+        const res = await apiFetch('/api/emergency');
+        if (res.ok) showEmergency(await res.json());
+    } catch (err) {
+        console.error('Error loading emergency state:', err);
+    }
+}
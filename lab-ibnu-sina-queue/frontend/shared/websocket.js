@@ -1,30 +1,95 @@
 class QueueWebSocket {
-    constructor(url, onMessage, onOpen) {
+    constructor(url, onMessage, onOpen, topics = []) {
         this.url = url;
         this.onMessage = onMessage;
         this.onOpen = onOpen;
         this.reconnectInterval = 3000;
+
+        // Position in the server's event stream, sent on reconnect so the
+        // server can replay what we missed (or send a SNAPSHOT)
+        this.epoch = null;
+        this.lastSeq = null;
+
+        // Topics to receive (e.g. 'category:1', 'counter:2'); empty means all
+        this.topics = topics;
+
+        // What a paired device reports after every connect, see register()
+        this.registration = null;
+
+        // Commands waiting for their ACK or ERROR, by ID
+        this.pending = {};
+        this.commandTimeout = 10000;
+
+        // Networks that block WebSocket upgrades get Server-Sent Events
+        // after a few failed attempts
+        this.sseUrl = url.replace(/^ws/, 'http').replace(/\/ws(\?|$)/, '/api/events$1');
+        this.failures = 0;
+        this.maxFailures = 3;
+        this.useSSE = !('WebSocket' in window);
+
         this.connect();
     }
 
+    connectUrl(base = this.url) {
+        const params = [];
+        if (this.topics.length > 0) {
+            params.push(`topics=${encodeURIComponent(this.topics.join(','))}`);
+        }
+        if (this.lastSeq !== null) {
+            params.push(`epoch=${encodeURIComponent(this.epoch)}`, `last_seq=${this.lastSeq}`);
+            params.push(`last_event_id=${encodeURIComponent(this.epoch + ':' + this.lastSeq)}`);
+        }
+        if (params.length === 0) return base;
+        const sep = base.includes('?') ? '&' : '?';
+        return `${base}${sep}${params.join('&')}`;
+    }
+
+    // register reports the device's location and version now and after
+    // every reconnect; the server replies with REGISTERED and its settings
+    register(info) {
+        this.registration = info;
+        if (this.isOpen()) this.send({ type: 'REGISTER', payload: info });
+    }
+
+    // subscribe limits the connection to the given topics, kept across
+    // reconnects
+    subscribe(topics) {
+        const wasAll = this.topics.length === 0;
+        this.topics = [...new Set([...this.topics, ...topics])];
+        this.send({ type: 'SUBSCRIBE', topics });
+        if (wasAll) this.send({ type: 'UNSUBSCRIBE', topics: ['*'] });
+    }
+
+    unsubscribe(topics) {
+        this.topics = this.topics.filter(t => !topics.includes(t));
+        if (this.topics.length === 0) this.send({ type: 'SUBSCRIBE', topics: ['*'] });
+        this.send({ type: 'UNSUBSCRIBE', topics });
+    }
+
     connect() {
-        this.ws = new WebSocket(this.url);
+        if (this.useSSE && 'EventSource' in window) {
+            this.connectSSE();
+            return;
+        }
+
+        let opened = false;
+        this.ws = new WebSocket(this.connectUrl());
 
         this.ws.onopen = () => {
             console.log('WebSocket Connected');
+            opened = true;
+            this.failures = 0;
+            if (this.registration) this.send({ type: 'REGISTER', payload: this.registration });
             if (this.onOpen) this.onOpen();
         };
 
-        this.ws.onmessage = (event) => {
-            try {
-                const data = JSON.parse(event.data);
-                if (this.onMessage) this.onMessage(data);
-            } catch (e) {
-                console.error('WebSocket message parsing error:', e);
-            }
-        };
+        this.ws.onmessage = (event) => this.receive(event.data);
 
         this.ws.onclose = () => {
+            if (!opened && ++this.failures >= this.maxFailures) {
+                console.log('WebSocket unavailable, switching to Server-Sent Events');
+                this.useSSE = true;
+            }
             console.log('WebSocket Disconnected. Reconnecting...');
             setTimeout(() => this.connect(), this.reconnectInterval);
         };
@@ -35,8 +100,98 @@ class QueueWebSocket {
         };
     }
 
+    // connectSSE listens on /api/events instead. The browser reconnects by
+    // itself and resumes with the Last-Event-ID header.
+    connectSSE() {
+        this.es = new EventSource(this.connectUrl(this.sseUrl));
+
+        this.es.onopen = () => {
+            console.log('Event stream connected');
+            if (this.onOpen) this.onOpen();
+        };
+
+        this.es.onmessage = (event) => this.receive(event.data);
+
+        this.es.onerror = () => {
+            if (this.es.readyState === EventSource.CLOSED) {
+                console.log('Event stream closed. Reconnecting...');
+                setTimeout(() => this.connect(), this.reconnectInterval);
+            }
+        };
+    }
+
+    receive(raw) {
+        try {
+            const data = JSON.parse(raw);
+            if (this.settle(data)) return;
+            if (!this.track(data)) return;
+            if (this.onMessage) this.onMessage(data);
+        } catch (e) {
+            console.error('WebSocket message parsing error:', e);
+        }
+    }
+
+    // track records the sequence of an event and reports whether it is new
+    track(message) {
+        if (typeof message.seq !== 'number') return true;
+
+        if (message.type !== 'SNAPSHOT' && message.epoch === this.epoch && message.seq <= this.lastSeq) {
+            return false;
+        }
+        this.epoch = message.epoch;
+        this.lastSeq = message.seq;
+        return true;
+    }
+
+    isOpen() {
+        return !this.useSSE && this.ws && this.ws.readyState === WebSocket.OPEN;
+    }
+
+    // command sends a request over the socket and resolves with its result.
+    // Sending again with the same id returns the original result.
+    command(name, payload, id) {
+        id = id || Date.now().toString(36) + Math.random().toString(36).slice(2);
+        if (this.pending[id]) return this.pending[id].promise;
+
+        const entry = {};
+        entry.promise = new Promise((resolve, reject) => {
+            if (!this.isOpen()) {
+                reject(new Error('WebSocket not open'));
+                return;
+            }
+            entry.resolve = resolve;
+            entry.reject = reject;
+            entry.timer = setTimeout(() => {
+                delete this.pending[id];
+                reject(new Error('Command timed out'));
+            }, this.commandTimeout);
+            this.pending[id] = entry;
+            this.ws.send(JSON.stringify({ type: 'COMMAND', id: id, command: name, payload: payload || {} }));
+        });
+        return entry.promise;
+    }
+
+    // settle resolves the command a reply belongs to; false for other messages
+    settle(message) {
+        if (message.type !== 'ACK' && message.type !== 'ERROR') return false;
+        const reply = message.data || {};
+        const entry = this.pending[reply.id];
+        if (!entry) return false;
+
+        delete this.pending[reply.id];
+        clearTimeout(entry.timer);
+        if (message.type === 'ACK') {
+            entry.resolve(reply.result);
+        } else {
+            const err = new Error(reply.message);
+            err.code = reply.code;
+            entry.reject(err);
+        }
+        return true;
+    }
+
     send(data) {
-        if (this.ws.readyState === WebSocket.OPEN) {
+        if (this.isOpen()) {
             this.ws.send(JSON.stringify(data));
         } else {
             console.warn('WebSocket not open. Cannot send data.');